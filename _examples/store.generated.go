@@ -1,131 +1,1194 @@
 package example
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"go/format"
+	"go/token"
+	"hash/fnv"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 // Store
 
+// StoreFuzzFailure is an error found by the Store fuzz
+// tester, with the operations which led to it.
+type StoreFuzzFailure struct {
+	// The seed the PRNG was created with, if HasSeed is true.
+	Seed    int64
+	HasSeed bool
+
+	// The maximum number of operations, and the arguments the
+	// implementations were made with, if known.
+	MaxOps   uint
+	MakeArgs []interface{}
+
+	// What went wrong: an inconsistent result or callback
+	// invocation, or a violated invariant.
+	Reason string
+
+	// The index in Trace of the operation which failed.
+	Index int
+
+	// The method called by the operation which failed, and its
+	// arguments. A callback is given as the results it returns.
+	Method string
+	Args   []interface{}
+
+	// The results returned by the reference and test
+	// implementations, by position. For an inconsistent callback
+	// these are the recorded invocations of the callback instead,
+	// and for a violated invariant they are nil.
+	Expected []interface{}
+	Actual   []interface{}
+
+	// The operations performed, up to and including the one which
+	// failed.
+	Trace []StoreFuzzCall
+}
+
+func (f *StoreFuzzFailure) Error() string {
+	msg := f.Reason
+	if f.Index >= 0 && f.Index < len(f.Trace) {
+		msg = msg + "\ncall: " + f.Trace[f.Index].String()
+	}
+	if f.Expected != nil || f.Actual != nil {
+		msg = msg + fmt.Sprintf("\nexpected: %s\nactual:   %s", fuzzValues(f.Expected), fuzzValues(f.Actual))
+	}
+	msg = msg + fmt.Sprintf("\ntrace of %d operations:", len(f.Trace))
+	for _, call := range f.Trace {
+		msg = msg + "\n\t" + call.String()
+	}
+	if f.HasSeed {
+		msg = msg + fmt.Sprintf("\nseed: %d", f.Seed)
+	}
+	return msg
+}
+
+// StoreFuzzCall is a method call made by the Store fuzz tester.
+type StoreFuzzCall struct {
+	// The method called.
+	Method string
+
+	// The arguments it was called with. A callback is given as the
+	// results it returns.
+	Args []interface{}
+
+	// A description of the call, with the names of the parameters.
+	description string
+}
+
+func (c StoreFuzzCall) String() string {
+	return c.description
+}
+
+// fuzzStoreOp is an operation performed by the Store fuzz
+// tester: a method, given by its index, and the arguments to call it
+// with.
+type fuzzStoreOp struct {
+	method int
+	args   []interface{}
+}
+
+// fuzzStoreCall describes an operation as a method call.
+func fuzzStoreCall(op fuzzStoreOp) StoreFuzzCall {
+	call := StoreFuzzCall{Args: op.args}
+
+	switch op.method {
+	case 0:
+		argMsg, _ := op.args[0].(Message)
+		call.Method = "Put"
+		call.description = fmt.Sprintf("Put(msg=%v)", argMsg)
+	case 1:
+		argSinceID, _ := op.args[0].(ID)
+		argChannel, _ := op.args[1].(Channel)
+		call.Method = "EntriesSince"
+		call.description = fmt.Sprintf("EntriesSince(sinceID=%v, channel=%v)", argSinceID, argChannel)
+	case 2:
+		call.Method = "MostRecentID"
+		call.description = "MostRecentID()"
+	case 3:
+		call.Method = "NumEntries"
+		call.description = "NumEntries()"
+	case 4:
+		call.Method = "AsSlice"
+		call.description = "AsSlice()"
+	case 5:
+		call.Method = "MessageLimit"
+		call.description = "MessageLimit()"
+	}
+
+	return call
+}
+
+// fuzzStoreFailure creates a failure for an operation.
+func fuzzStoreFailure(op fuzzStoreOp, reason string, expected, actual []interface{}) *StoreFuzzFailure {
+	call := fuzzStoreCall(op)
+	return &StoreFuzzFailure{Reason: reason, Method: call.Method, Args: call.Args, Expected: expected, Actual: actual}
+}
+
+// fuzzStoreTrace records the operations leading up to a failure in
+// it. The last of the operations is the one which failed.
+func fuzzStoreTrace(ops []fuzzStoreOp, err error) error {
+	var failure *StoreFuzzFailure
+	if !errors.As(err, &failure) {
+		return err
+	}
+
+	failure.Index = len(ops) - 1
+	failure.Trace = nil
+	for _, op := range ops {
+		failure.Trace = append(failure.Trace, fuzzStoreCall(op))
+	}
+	return failure
+}
+
 func FuzzTestStore(makeTest func(int) Store, t *testing.T) {
-	rand := rand.New(rand.NewSource(0))
+	seed := int64(0)
+	rand := rand.New(rand.NewSource(seed))
 
 	err := FuzzStore(makeTest, rand, 100)
 
 	if err != nil {
+		var failure *StoreFuzzFailure
+		if errors.As(err, &failure) {
+			failure.Seed, failure.HasSeed = seed, true
+
+			if dir := os.Getenv("FUZZ_REGRESSION_DIR"); dir != "" {
+				path, err := fuzzStoreWriteRegression(dir, failure, makeTest)
+				if err != nil {
+					t.Logf("could not write regression test: %s", err)
+				} else {
+					t.Logf("wrote regression test to %s", path)
+				}
+			}
+		}
 		t.Error(err)
 	}
 }
 
+// fuzzStoreWriteRegression writes a regression test for a failure
+// to a new file in a directory, and returns its path. The test
+// implementation is made by makeTest, which must be a named function.
+func fuzzStoreWriteRegression(dir string, failure *StoreFuzzFailure, makeTest interface{}) (string, error) {
+	fn := runtime.FuncForPC(reflect.ValueOf(makeTest).Pointer())
+	if fn == nil {
+		return "", errors.New("makeTest is not a named function")
+	}
+	makeName := strings.TrimPrefix(fn.Name(), reflect.TypeOf(*failure).PkgPath()+".")
+	if !token.IsIdentifier(makeName) {
+		return "", fmt.Errorf("makeTest is not a named function in this package: %s", fn.Name())
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(failure.Error()))
+	id := fmt.Sprintf("%08x", hash.Sum32())
+
+	code, err := failure.RegressionTest("TestStoreRegression"+id, makeName)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "fuzz_"+strings.ToLower("Store")+"_regression_"+id+"_test.go")
+	return path, os.WriteFile(path, []byte(code), 0644)
+}
+
+func FuzzStoreNative(f *testing.F, makeTest func(int) Store) {
+	// Start from the same operations as FuzzTestStore.
+	f.Add(fuzzNativeSeed())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Every operation takes at least one value from the
+		// input, and the run stops once it is used up.
+		maxops := uint(len(data) / 8)
+		if maxops > 100 {
+			maxops = 100
+		}
+		source := &fuzzByteSource{data: data}
+
+		err := fuzzStore(makeTest, rand.New(source), maxops, source.Exhausted)
+
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
 func FuzzStore(makeTest func(int) Store, rand *rand.Rand, max uint) error {
+	return fuzzStore(makeTest, rand, max, nil)
+}
+
+// fuzzStore is FuzzStore, stopping the run early once done
+// returns true, if it is not nil.
+func fuzzStore(makeTest func(int) Store, rand *rand.Rand, max uint, done func() bool) error {
 	var (
 		argInt int
 	)
 
-	argInt = rand.Int()
+	argInt = fuzzEdge(rand, 0.1, rand.Int(), 0, 1, -1, math.MaxInt, math.MinInt)
+
+	// Create a fresh pair of implementations.
+	newImplementations := func() (Store, Store) {
+		expectedStore := makeReferenceStore(argInt)
+		actualStore := makeTest(argInt)
+
+		return &expectedStore, actualStore
+	}
+
+	reference, test := newImplementations()
+	ops, err := fuzzStoreRun(reference, test, rand, max, done)
+	if err == nil {
+		return nil
+	}
+
+	// Replay a sequence of operations against a fresh pair of
+	// implementations.
+	replay := func(ops []fuzzStoreOp) (int, error) {
+		reference, test := newImplementations()
+		var callbacks []fuzzStoreCallback
+		for i, op := range ops {
+			if err := fuzzStoreStep(reference, test, op, nil, &callbacks); err != nil {
+				return i + 1, err
+			}
+		}
+		return len(ops), nil
+	}
+
+	// Shrink the failing sequence of operations, and then their
+	// arguments.
+	ops, err = fuzzStoreShrink(ops, err, replay)
+	ops, err = fuzzStoreShrinkArgs(ops, err, replay)
 
-	expectedStore := makeReferenceStore(argInt)
-	actualStore := makeTest(argInt)
+	err = fuzzStoreTrace(ops, err)
 
-	return FuzzStoreWith(&expectedStore, actualStore, rand, max)
+	var failure *StoreFuzzFailure
+	if errors.As(err, &failure) {
+		failure.MaxOps = max
+		failure.MakeArgs = []interface{}{argInt}
+	}
+	return err
+}
+
+// RegressionTest renders a Go test file, with a test function named
+// testName, which replays the operations of a failure against a
+// reference implementation and one made by makeTest, which should be
+// the name of a function in the same package. If the arguments can't
+// all be written as Go literals, the test instead runs FuzzStore
+// again with the same seed.
+func (f *StoreFuzzFailure) RegressionTest(testName, makeTest string) (string, error) {
+	failureType := reflect.TypeOf(*f)
+	pkg := failureType.PkgPath()
+	imports := map[string]bool{"\"testing\"": true}
+
+	var body []string
+	makeArgs, ok := fuzzLiterals(f.MakeArgs, pkg, imports)
+	if ok && len(makeArgs) == 1 {
+		body = append(body, "makeTest := "+makeTest)
+		body = append(body, fmt.Sprintf("argInt := %s", makeArgs[0]))
+		body = append(body, "expectedStore := makeReferenceStore(argInt)\nactualStore := makeTest(argInt)", "reference, test := &expectedStore, actualStore")
+
+		for _, call := range f.Trace {
+			var code string
+			code, ok = fuzzStoreRegressionCall(call, pkg, imports)
+			if !ok {
+				break
+			}
+			body = append(body, "", "// "+call.String(), code, "if !(test.NumEntries() == len(test.AsSlice())) {\n\tt.Fatalf(\"%s\", \"invariant violated: test.NumEntries() == len(test.AsSlice())\")\n}\nif !(test.NumEntries() <= test.MessageLimit()) {\n\tt.Fatalf(\"%s\", \"invariant violated: test.NumEntries() <= test.MessageLimit()\")\n}")
+		}
+	}
+
+	if !ok {
+		if !f.HasSeed {
+			return "", errors.New("the arguments can't be written as Go literals, and the seed is not known")
+		}
+		imports = map[string]bool{"\"testing\"": true, "\"math/rand\"": true}
+		body = []string{
+			fmt.Sprintf("err := FuzzStore(%s, rand.New(rand.NewSource(%d)), %d)", makeTest, f.Seed, f.MaxOps),
+			"if err != nil {\n\tt.Fatal(err)\n}",
+		}
+	}
+
+	var specs []string
+	for spec := range imports {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+
+	code := "package " + strings.TrimSuffix(failureType.String(), "."+failureType.Name()) + "\n\n"
+	code = code + "import (\n" + strings.Join(specs, "\n") + "\n)\n\n"
+	code = code + "// " + testName + " replays a failure found by FuzzStore:\n//\n"
+	for _, line := range strings.Split(f.Error(), "\n") {
+		code = code + "//\t" + line + "\n"
+	}
+	code = code + "func " + testName + "(t *testing.T) {\n" + strings.Join(body, "\n") + "\n}\n"
+
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+// fuzzStoreRegressionCall renders a call as the code of a
+// regression test, which makes it on both implementations and checks
+// the results.
+func fuzzStoreRegressionCall(call StoreFuzzCall, pkg string, imports map[string]bool) (string, bool) {
+	var code string
+	switch call.Method {
+	case "Put":
+		code = "{\n\texpectedError := reference.Put(%[1]s)\n\tactualError := test.Put(%[1]s)\n\tif !((expectedError == nil) == (actualError == nil)) {\n\t\tt.Fatalf(\"inconsistent result in Put\\nexpected: %%v\\nactual:   %%v\", expectedError, actualError)\n\t}\n}"
+	case "EntriesSince":
+		code = "{\n\texpectedID, expectedMessage := reference.EntriesSince(%[1]s, %[2]s)\n\tactualID, actualMessage := test.EntriesSince(%[1]s, %[2]s)\n\tif !reflect.DeepEqual(expectedID, actualID) {\n\t\tt.Fatalf(\"inconsistent result in EntriesSince\\nexpected: %%v\\nactual:   %%v\", expectedID, actualID)\n\t}\n\tif !reflect.DeepEqual(expectedMessage, actualMessage) {\n\t\tt.Fatalf(\"inconsistent result in EntriesSince\\nexpected: %%v\\nactual:   %%v\", expectedMessage, actualMessage)\n\t}\n}"
+		imports["\"reflect\""] = true
+	case "MostRecentID":
+		code = "{\n\texpectedID := reference.MostRecentID()\n\tactualID := test.MostRecentID()\n\tif !reflect.DeepEqual(expectedID, actualID) {\n\t\tt.Fatalf(\"inconsistent result in MostRecentID\\nexpected: %%v\\nactual:   %%v\", expectedID, actualID)\n\t}\n}"
+		imports["\"reflect\""] = true
+	case "NumEntries":
+		code = "{\n\texpectedInt := reference.NumEntries()\n\tactualInt := test.NumEntries()\n\tif !reflect.DeepEqual(expectedInt, actualInt) {\n\t\tt.Fatalf(\"inconsistent result in NumEntries\\nexpected: %%v\\nactual:   %%v\", expectedInt, actualInt)\n\t}\n}"
+		imports["\"reflect\""] = true
+	case "AsSlice":
+		code = "{\n\texpectedMessage := reference.AsSlice()\n\tactualMessage := test.AsSlice()\n\tif !reflect.DeepEqual(expectedMessage, actualMessage) {\n\t\tt.Fatalf(\"inconsistent result in AsSlice\\nexpected: %%v\\nactual:   %%v\", expectedMessage, actualMessage)\n\t}\n}"
+		imports["\"reflect\""] = true
+	case "MessageLimit":
+		code = "{\n\texpectedInt := reference.MessageLimit()\n\tactualInt := test.MessageLimit()\n\tif !reflect.DeepEqual(expectedInt, actualInt) {\n\t\tt.Fatalf(\"inconsistent result in MessageLimit\\nexpected: %%v\\nactual:   %%v\", expectedInt, actualInt)\n\t}\n}"
+		imports["\"reflect\""] = true
+	}
+	if code == "" {
+		return "", false
+	}
+
+	args, ok := fuzzLiterals(call.Args, pkg, imports)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(code, args...), true
+}
+
+// fuzzStoreShrink removes operations from a failing sequence for
+// as long as the sequence still fails, first in large chunks and then
+// one at a time. The replay function reports how many operations were
+// performed before the failure, if there was one.
+func fuzzStoreShrink(ops []fuzzStoreOp, err error, replay func([]fuzzStoreOp) (int, error)) ([]fuzzStoreOp, error) {
+	size := len(ops) / 2
+	for size > 0 {
+		removed := false
+		for start := 0; start+size <= len(ops); {
+			candidate := make([]fuzzStoreOp, 0, len(ops)-size)
+			candidate = append(candidate, ops[:start]...)
+			candidate = append(candidate, ops[start+size:]...)
+
+			if n, cerr := replay(candidate); cerr != nil {
+				ops, err = candidate[:n], cerr
+				removed = true
+			} else {
+				start += size
+			}
+		}
+
+		if size > 1 {
+			size = size / 2
+		} else if !removed {
+			size = 0
+		}
+	}
+
+	return ops, err
+}
+
+// fuzzStoreShrinkArgs shrinks the arguments of the operations in a
+// failing sequence, one at a time, for as long as the sequence still
+// fails. At most 1000 candidates are tried.
+func fuzzStoreShrinkArgs(ops []fuzzStoreOp, err error, replay func([]fuzzStoreOp) (int, error)) ([]fuzzStoreOp, error) {
+	attempts := 0
+	for i := 0; i < len(ops); i++ {
+		for shrunk := true; shrunk; {
+			shrunk = false
+			for _, op := range fuzzStoreShrinkOp(ops[i]) {
+				if attempts++; attempts > 1000 {
+					return ops, err
+				}
+
+				candidate := make([]fuzzStoreOp, len(ops))
+				copy(candidate, ops)
+				candidate[i] = op
+
+				if n, cerr := replay(candidate); cerr != nil {
+					ops, err = candidate[:n], cerr
+					shrunk = i < len(ops)
+					break
+				}
+			}
+		}
+	}
+
+	return ops, err
+}
+
+// fuzzStoreShrinkOp produces smaller variants of an operation, by
+// shrinking one of its arguments.
+func fuzzStoreShrinkOp(op fuzzStoreOp) []fuzzStoreOp {
+	var candidates []fuzzStoreOp
+
+	switch op.method {
+	}
+
+	return candidates
+}
+
+// fuzzStorePools holds values returned by earlier operations, to
+// be reused as the arguments of later ones.
+type fuzzStorePools struct {
+}
+
+// fuzzStoreCallback holds the recorded invocations of a callback
+// passed to both implementations by an operation. The implementations
+// may keep the callback and invoke it during later operations.
+type fuzzStoreCallback struct {
+	method   string
+	expected *[][]interface{}
+	actual   *[][]interface{}
 }
 
 func FuzzStoreWith(reference Store, test Store, rand *rand.Rand, maxops uint) error {
+	ops, err := fuzzStoreRun(reference, test, rand, maxops, nil)
+	if err != nil {
+		return fuzzStoreTrace(ops, err)
+	}
+	return nil
+}
+
+// fuzzStoreRun performs random operations on both implementations,
+// returning the operations performed up to and including the first to
+// fail. If done is not nil, no more operations are performed once it
+// returns true.
+func fuzzStoreRun(reference Store, test Store, rand *rand.Rand, maxops uint, done func() bool) ([]fuzzStoreOp, error) {
 	// Create initial state
 	state := uint(0)
 
+	pools := &fuzzStorePools{}
+	var callbacks []fuzzStoreCallback
+
+	var ops []fuzzStoreOp
 	for i := uint(0); i < maxops; i++ {
-		// Pick a random number between 0 and the number of methods of the interface. Then do that method on
-		// both, check for discrepancy, and bail out on error. Simple!
+		if done != nil && done() {
+			break
+		}
+
+		// Pick a random number between 0 and the number of methods of the interface. Then generate the
+		// arguments for that method.
 
+		var op fuzzStoreOp
 		actionToPerform := rand.Intn(6)
 
 		switch actionToPerform {
 		case 0:
-			// Call the method on both implementations
 			var (
-				argMessage Message
+				argMsg Message
+			)
+
+			argMsg, state = generateMessage(rand, state)
+
+			op = fuzzStoreOp{method: 0, args: []interface{}{argMsg}}
+		case 1:
+			var (
+				argSinceID ID
+				argChannel Channel
 			)
 
-			argMessage, state = generateMessage(rand, state)
+			argSinceID, state = generateID(rand, state)
+			argChannel = generateChannel(rand)
 
-			expectedError := reference.Put(argMessage)
-			actualError := test.Put(argMessage)
+			op = fuzzStoreOp{method: 1, args: []interface{}{argSinceID, argChannel}}
+		case 2:
+			op = fuzzStoreOp{method: 2}
+		case 3:
+			op = fuzzStoreOp{method: 3}
+		case 4:
+			op = fuzzStoreOp{method: 4}
+		case 5:
+			op = fuzzStoreOp{method: 5}
+		}
 
-			// And check for discrepancies.
-			if !((expectedError == nil) == (actualError == nil)) {
-				return fmt.Errorf("inconsistent result in Put\nexpected: %v\nactual:   %v", expectedError, actualError)
-			}
+		// Then do that operation on both, and bail out on error. Simple!
+		ops = append(ops, op)
+		if err := fuzzStoreStep(reference, test, op, pools, &callbacks); err != nil {
+			return ops, err
+		}
+	}
+
+	return ops, nil
+}
+
+// fuzzStoreStep performs an operation on both implementations, and
+// checks the invariants still hold. If pools is not nil, the values it
+// returns are added to them. The callbacks passed by earlier
+// operations are checked too.
+func fuzzStoreStep(reference Store, test Store, op fuzzStoreOp, pools *fuzzStorePools, callbacks *[]fuzzStoreCallback) error {
+	if err := fuzzStoreApply(reference, test, op, pools, callbacks); err != nil {
+		return err
+	}
+
+	if !(test.NumEntries() == len(test.AsSlice())) {
+		return fuzzStoreFailure(op, "invariant violated: %var.NumEntries() == len(%var.AsSlice())", nil, nil)
+	}
+
+	if !(test.NumEntries() <= test.MessageLimit()) {
+		return fuzzStoreFailure(op, "invariant violated: %var.NumEntries() <= %var.MessageLimit()", nil, nil)
+	}
+
+	return nil
+}
+
+// fuzzStoreApply calls the method of an operation on both
+// implementations, and checks the results for discrepancies. If pools
+// is not nil, the values returned by the reference implementation are
+// added to them. The callbacks passed to the method are added to
+// callbacks, and all of them are checked, as the implementations may
+// keep a callback and invoke it later.
+func fuzzStoreApply(reference Store, test Store, op fuzzStoreOp, pools *fuzzStorePools, callbacks *[]fuzzStoreCallback) error {
+	switch op.method {
+	case 0:
+		argMsg, _ := op.args[0].(Message)
+
+		// Call the method on both implementations
+		expectedError := reference.Put(argMsg)
+		actualError := test.Put(argMsg)
+
+		// And check for discrepancies.
+		if !((expectedError == nil) == (actualError == nil)) {
+			return fuzzStoreFailure(op, "inconsistent result in Put", []interface{}{expectedError}, []interface{}{actualError})
+		}
+	case 1:
+		argSinceID, _ := op.args[0].(ID)
+		argChannel, _ := op.args[1].(Channel)
+
+		// Call the method on both implementations
+		expectedID, expectedMessage := reference.EntriesSince(argSinceID, argChannel)
+		actualID, actualMessage := test.EntriesSince(argSinceID, argChannel)
+
+		// And check for discrepancies.
+		if !reflect.DeepEqual(expectedID, actualID) {
+			return fuzzStoreFailure(op, "inconsistent result in EntriesSince", []interface{}{expectedID, expectedMessage}, []interface{}{actualID, actualMessage})
+		}
+		if !reflect.DeepEqual(expectedMessage, actualMessage) {
+			return fuzzStoreFailure(op, "inconsistent result in EntriesSince", []interface{}{expectedID, expectedMessage}, []interface{}{actualID, actualMessage})
+		}
+	case 2:
+		// Call the method on both implementations
+		expectedID := reference.MostRecentID()
+		actualID := test.MostRecentID()
+
+		// And check for discrepancies.
+		if !reflect.DeepEqual(expectedID, actualID) {
+			return fuzzStoreFailure(op, "inconsistent result in MostRecentID", []interface{}{expectedID}, []interface{}{actualID})
+		}
+	case 3:
+		// Call the method on both implementations
+		expectedInt := reference.NumEntries()
+		actualInt := test.NumEntries()
+
+		// And check for discrepancies.
+		if !reflect.DeepEqual(expectedInt, actualInt) {
+			return fuzzStoreFailure(op, "inconsistent result in NumEntries", []interface{}{expectedInt}, []interface{}{actualInt})
+		}
+	case 4:
+		// Call the method on both implementations
+		expectedMessage := reference.AsSlice()
+		actualMessage := test.AsSlice()
+
+		// And check for discrepancies.
+		if !reflect.DeepEqual(expectedMessage, actualMessage) {
+			return fuzzStoreFailure(op, "inconsistent result in AsSlice", []interface{}{expectedMessage}, []interface{}{actualMessage})
+		}
+	case 5:
+		// Call the method on both implementations
+		expectedInt := reference.MessageLimit()
+		actualInt := test.MessageLimit()
+
+		// And check for discrepancies.
+		if !reflect.DeepEqual(expectedInt, actualInt) {
+			return fuzzStoreFailure(op, "inconsistent result in MessageLimit", []interface{}{expectedInt}, []interface{}{actualInt})
+		}
+	}
+
+	return nil
+}
+
+func FuzzStoreInvariants(test Store, rand *rand.Rand, maxops uint) error {
+	// Create initial state
+	state := uint(0)
+
+	var ops []fuzzStoreOp
+	for i := uint(0); i < maxops; i++ {
+		// Pick a random number between 0 and the number of methods of the interface. Then do that method,
+		// and check the invariants still hold.
+
+		var op fuzzStoreOp
+		actionToPerform := rand.Intn(6)
+
+		switch actionToPerform {
+		case 0:
+			// Call the method on the implementation
+			var (
+				argMsg Message
+			)
+
+			argMsg, state = generateMessage(rand, state)
+
+			op = fuzzStoreOp{method: 0, args: []interface{}{argMsg}}
+			test.Put(argMsg)
 		case 1:
-			// Call the method on both implementations
+			// Call the method on the implementation
 			var (
-				argID      ID
+				argSinceID ID
 				argChannel Channel
 			)
 
-			argID, state = generateID(rand, state)
+			argSinceID, state = generateID(rand, state)
 			argChannel = generateChannel(rand)
 
-			expectedID, expectedMessage := reference.EntriesSince(argID, argChannel)
-			actualID, actualMessage := test.EntriesSince(argID, argChannel)
+			op = fuzzStoreOp{method: 1, args: []interface{}{argSinceID, argChannel}}
+			test.EntriesSince(argSinceID, argChannel)
+		case 2:
+			// Call the method on the implementation
+			op = fuzzStoreOp{method: 2}
+			test.MostRecentID()
+		case 3:
+			// Call the method on the implementation
+			op = fuzzStoreOp{method: 3}
+			test.NumEntries()
+		case 4:
+			// Call the method on the implementation
+			op = fuzzStoreOp{method: 4}
+			test.AsSlice()
+		case 5:
+			// Call the method on the implementation
+			op = fuzzStoreOp{method: 5}
+			test.MessageLimit()
+		}
+		ops = append(ops, op)
+
+		if !(test.NumEntries() == len(test.AsSlice())) {
+			return fuzzStoreTrace(ops, fuzzStoreFailure(op, "invariant violated: %var.NumEntries() == len(%var.AsSlice())", nil, nil))
+		}
+
+		if !(test.NumEntries() <= test.MessageLimit()) {
+			return fuzzStoreTrace(ops, fuzzStoreFailure(op, "invariant violated: %var.NumEntries() <= %var.MessageLimit()", nil, nil))
+		}
+	}
+
+	return nil
+}
+
+// Generators
+
+// fuzzSize gives the size of the values generated for the ith of
+// maxops operations, which grows linearly up to max.
+func fuzzSize(i, maxops uint, max int) int {
+	return int((uint(max)*(i+1) + maxops - 1) / maxops)
+}
+
+// fuzzEdge gives one of the boundary values with probability bias, and
+// the uniform value otherwise.
+func fuzzEdge[T any](rand *rand.Rand, bias float64, uniform T, edges ...T) T {
+	if rand.Float64() < bias {
+		return edges[rand.Intn(len(edges))]
+	}
+	return uniform
+}
+
+// fuzzContains checks if a value is one of a set.
+func fuzzContains[T comparable](values []T, v T) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzLength generates a length of at most size, which is zero or size
+// with probability bias.
+func fuzzLength(rand *rand.Rand, size int, bias float64) int {
+	if bias > 0 && rand.Float64() < bias {
+		return size * rand.Intn(2)
+	}
+	return rand.Intn(size + 1)
+}
+
+// fuzzLengthRange generates a length between min and max inclusive,
+// which is at most size more than min.
+func fuzzLengthRange(rand *rand.Rand, size, min, max int) int {
+	if max > min+size {
+		max = min + size
+	}
+	return min + rand.Intn(max-min+1)
+}
+
+// fuzzString generates a string of printable ASCII characters, of
+// length at most size.
+func fuzzString(rand *rand.Rand, size int, bias float64) string {
+	runes := make([]rune, fuzzLength(rand, size, bias))
+	for i := range runes {
+		runes[i] = rune(' ' + rand.Intn('~'-' '+1))
+	}
+	return string(runes)
+}
+
+// fuzzBytes generates a slice of bytes, of length at most size.
+func fuzzBytes(rand *rand.Rand, size int, bias float64) []byte {
+	bytes := make([]byte, fuzzLength(rand, size, bias))
+	rand.Read(bytes)
+	return bytes
+}
+
+// Failures
+
+// fuzzLiterals renders values as Go literals, for a regression test in
+// the package with the given path. Any other packages the literals
+// use are added to imports.
+func fuzzLiterals(values []interface{}, pkg string, imports map[string]bool) ([]interface{}, bool) {
+	var literals []interface{}
+	for i := range values {
+		literal, ok := fuzzLiteral(reflect.ValueOf(&values[i]).Elem(), pkg, imports)
+		if !ok {
+			return nil, false
+		}
+		literals = append(literals, literal)
+	}
+	return literals, true
+}
+
+// fuzzLiteral renders a value as a Go literal. Functions, channels,
+// non-nil pointers to anything but structs, and values with
+// unexported fields from other packages can't be rendered.
+func fuzzLiteral(v reflect.Value, pkg string, imports map[string]bool) (string, bool) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "nil", true
+		}
+		v = v.Elem()
+	}
+
+	ty, ok := fuzzTypeName(v.Type(), pkg, imports)
+	if !ok {
+		return "", false
+	}
+
+	// Constants are converted to their type, unless it is the
+	// default type of the constant.
+	convert := func(literal, def string) (string, bool) {
+		if ty == def {
+			return literal, true
+		}
+		return ty + "(" + literal + ")", true
+	}
+	float := func(f float64, bits int) (string, bool) {
+		switch {
+		case math.IsNaN(f):
+			imports["\"math\""] = true
+			return "math.NaN()", true
+		case math.IsInf(f, 0):
+			imports["\"math\""] = true
+			return fmt.Sprintf("math.Inf(%d)", int(math.Copysign(1, f))), true
+		case f == 0 && math.Signbit(f):
+			imports["\"math\""] = true
+			return "math.Copysign(0, -1)", true
+		}
+		return strconv.FormatFloat(f, 'g', -1, bits), true
+	}
 
-			// And check for discrepancies.
-			if !reflect.DeepEqual(expectedID, actualID) {
-				return fmt.Errorf("inconsistent result in EntriesSince\nexpected: %v\nactual:   %v", expectedID, actualID)
+	switch v.Kind() {
+	case reflect.Bool:
+		return convert(strconv.FormatBool(v.Bool()), "bool")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return convert(strconv.FormatInt(v.Int(), 10), "int")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return convert(strconv.FormatUint(v.Uint(), 10), "")
+	case reflect.Float32, reflect.Float64:
+		f, ok := float(v.Float(), v.Type().Bits())
+		if !ok {
+			return "", false
+		}
+		return convert(f, "")
+	case reflect.Complex64, reflect.Complex128:
+		re, reok := float(real(v.Complex()), v.Type().Bits()/2)
+		im, imok := float(imag(v.Complex()), v.Type().Bits()/2)
+		if !(reok && imok) {
+			return "", false
+		}
+		return convert("complex("+re+", "+im+")", "complex128")
+	case reflect.String:
+		return convert(strconv.Quote(v.String()), "string")
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "(" + ty + ")(nil)", true
+		}
+		var elems []string
+		for i := 0; i < v.Len(); i++ {
+			elem, ok := fuzzLiteral(v.Index(i), pkg, imports)
+			if !ok {
+				return "", false
 			}
-			if !reflect.DeepEqual(expectedMessage, actualMessage) {
-				return fmt.Errorf("inconsistent result in EntriesSince\nexpected: %v\nactual:   %v", expectedMessage, actualMessage)
+			elems = append(elems, elem)
+		}
+		return ty + "{" + strings.Join(elems, ", ") + "}", true
+	case reflect.Map:
+		if v.IsNil() {
+			return "(" + ty + ")(nil)", true
+		}
+		var entries []string
+		iter := v.MapRange()
+		for iter.Next() {
+			key, keyok := fuzzLiteral(iter.Key(), pkg, imports)
+			value, valueok := fuzzLiteral(iter.Value(), pkg, imports)
+			if !(keyok && valueok) {
+				return "", false
 			}
-		case 2:
-			// Call the method on both implementations
-			expectedID := reference.MostRecentID()
-			actualID := test.MostRecentID()
-
-			// And check for discrepancies.
-			if !reflect.DeepEqual(expectedID, actualID) {
-				return fmt.Errorf("inconsistent result in MostRecentID\nexpected: %v\nactual:   %v", expectedID, actualID)
+			entries = append(entries, key+": "+value)
+		}
+		sort.Strings(entries)
+		return ty + "{" + strings.Join(entries, ", ") + "}", true
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "(" + ty + ")(nil)", true
+		}
+		if v.Elem().Kind() != reflect.Struct {
+			return "", false
+		}
+		elem, ok := fuzzLiteral(v.Elem(), pkg, imports)
+		return "&" + elem, ok
+	case reflect.Struct:
+		var fields []string
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" && field.PkgPath != pkg {
+				return "", false
 			}
-		case 3:
-			// Call the method on both implementations
-			expectedInt := reference.NumEntries()
-			actualInt := test.NumEntries()
-
-			// And check for discrepancies.
-			if !reflect.DeepEqual(expectedInt, actualInt) {
-				return fmt.Errorf("inconsistent result in NumEntries\nexpected: %v\nactual:   %v", expectedInt, actualInt)
+			value, ok := fuzzLiteral(v.Field(i), pkg, imports)
+			if !ok {
+				return "", false
 			}
-		case 4:
-			// Call the method on both implementations
-			expectedMessage := reference.AsSlice()
-			actualMessage := test.AsSlice()
+			fields = append(fields, field.Name+": "+value)
+		}
+		return ty + "{" + strings.Join(fields, ", ") + "}", true
+	}
 
-			// And check for discrepancies.
-			if !reflect.DeepEqual(expectedMessage, actualMessage) {
-				return fmt.Errorf("inconsistent result in AsSlice\nexpected: %v\nactual:   %v", expectedMessage, actualMessage)
-			}
-		case 5:
-			// Call the method on both implementations
-			expectedInt := reference.MessageLimit()
-			actualInt := test.MessageLimit()
+	return "", false
+}
+
+// fuzzTypeName renders a type for a regression test in the package
+// with the given path. Types from other packages are qualified by the
+// package name, and the packages added to imports.
+func fuzzTypeName(ty reflect.Type, pkg string, imports map[string]bool) (string, bool) {
+	if ty.Name() != "" {
+		switch {
+		case strings.Contains(ty.Name(), "["):
+			// The type arguments of a generic type are not
+			// qualified in the same way.
+			return "", false
+		case ty.PkgPath() == "" || ty.PkgPath() == pkg:
+			return ty.Name(), true
+		}
+		imports[strconv.Quote(ty.PkgPath())] = true
+		return ty.String(), true
+	}
+
+	switch ty.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Ptr:
+		elem, ok := fuzzTypeName(ty.Elem(), pkg, imports)
+		switch ty.Kind() {
+		case reflect.Slice:
+			return "[]" + elem, ok
+		case reflect.Array:
+			return fmt.Sprintf("[%d]%s", ty.Len(), elem), ok
+		}
+		return "*" + elem, ok
+	case reflect.Map:
+		key, keyok := fuzzTypeName(ty.Key(), pkg, imports)
+		elem, elemok := fuzzTypeName(ty.Elem(), pkg, imports)
+		return "map[" + key + "]" + elem, keyok && elemok
+	case reflect.Interface:
+		if ty.NumMethod() == 0 {
+			return "interface{}", true
+		}
+	}
+
+	return "", false
+}
+
+// fuzzValues renders a list of values, separated by commas.
+func fuzzValues(values []interface{}) string {
+	var strs []string
+	for _, value := range values {
+		strs = append(strs, fmt.Sprintf("%v", value))
+	}
+	return strings.Join(strs, ", ")
+}
+
+// Copying
+
+// fuzzCopy makes a deep copy of a value, so that an implementation
+// which modifies its arguments doesn't change those of the other
+// implementation, or of a later replay. Unexported fields of structs
+// are copied shallowly, and pointers to the same value are copied to
+// pointers to the same copy.
+func fuzzCopy[T any](v T) T {
+	copied, _ := fuzzCopyValue(reflect.ValueOf(&v).Elem(), map[uintptr]reflect.Value{}).Interface().(T)
+	return copied
+}
+
+func fuzzCopyValue(v reflect.Value, seen map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		if copied, ok := seen[v.Pointer()]; ok && copied.Type() == v.Type() {
+			return copied
+		}
+		copied := reflect.New(v.Type().Elem())
+		seen[v.Pointer()] = copied
+		copied.Elem().Set(fuzzCopyValue(v.Elem(), seen))
+		return copied
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(fuzzCopyValue(v.Index(i), seen))
+		}
+		return copied
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			copied.SetMapIndex(iter.Key(), fuzzCopyValue(iter.Value(), seen))
+		}
+		return copied
+
+	case reflect.Array:
+		copied := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(fuzzCopyValue(v.Index(i), seen))
+		}
+		return copied
 
-			// And check for discrepancies.
-			if !reflect.DeepEqual(expectedInt, actualInt) {
-				return fmt.Errorf("inconsistent result in MessageLimit\nexpected: %v\nactual:   %v", expectedInt, actualInt)
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if copied.Field(i).CanSet() {
+				copied.Field(i).Set(fuzzCopyValue(v.Field(i), seen))
 			}
 		}
+		return copied
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(fuzzCopyValue(v.Elem(), seen))
+		return copied
+	}
+
+	return v
+}
+
+// Native fuzzing
+
+// fuzzByteSource is a rand.Source which takes its values from the
+// input of a native fuzz test, 8 bytes at a time. Once the input runs
+// out it gives zero, and is exhausted.
+type fuzzByteSource struct {
+	data      []byte
+	exhausted bool
+}
+
+func (s *fuzzByteSource) Uint64() uint64 {
+	if len(s.data) == 0 {
+		s.exhausted = true
+	}
+
+	var v uint64
+	for i := 0; i < 8 && len(s.data) > 0; i++ {
+		v = v<<8 | uint64(s.data[0])
+		s.data = s.data[1:]
+	}
+	return v
+}
+
+func (s *fuzzByteSource) Int63() int64 {
+	return int64(s.Uint64() &^ (1 << 63))
+}
+
+func (s *fuzzByteSource) Seed(int64) {}
+
+// Exhausted reports whether a value has been asked for after the
+// input ran out.
+func (s *fuzzByteSource) Exhausted() bool {
+	return s.exhausted
+}
+
+// fuzzNativeSeed encodes the values of the source used by the
+// FuzzTest functions as the input of a native fuzz test, so that a
+// fuzzByteSource gives the same values. Enough are encoded for 100
+// operations with several arguments each.
+func fuzzNativeSeed() []byte {
+	source := rand.NewSource(0).(rand.Source64)
+	seed := make([]byte, 8*4096)
+	for i := 0; i < len(seed); i += 8 {
+		binary.BigEndian.PutUint64(seed[i:], source.Uint64())
+	}
+	return seed
+}
+
+// Shrinking
+
+// fuzzShrinkBool shrinks true to false.
+func fuzzShrinkBool[T ~bool](v T) []T {
+	if !v {
+		return nil
+	}
+	return []T{false}
+}
+
+// fuzzShrinkInteger produces integers closer to zero.
+func fuzzShrinkInteger[T ~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr](v T) []T {
+	if v == 0 {
+		return nil
+	}
+
+	candidates := []T{0}
+	for delta := v / 2; delta != 0; delta /= 2 {
+		candidates = append(candidates, v-delta)
+	}
+	return candidates
+}
+
+// fuzzShrinkFloat produces floats closer to zero, without a fractional
+// part.
+func fuzzShrinkFloat[T ~float32 | ~float64](v T) []T {
+	if v == 0 {
+		return nil
+	}
+	if v != v {
+		return []T{0}
+	}
+
+	candidates := []T{0}
+	if trunc := T(math.Trunc(float64(v))); trunc != v {
+		candidates = append(candidates, trunc)
+	} else if half := T(math.Trunc(float64(v / 2))); half != v {
+		candidates = append(candidates, half)
+	}
+	return candidates
+}
+
+// fuzzShrinkComplex shrinks complex numbers to zero.
+func fuzzShrinkComplex[T ~complex64 | ~complex128](v T) []T {
+	if v == 0 {
+		return nil
+	}
+	return []T{0}
+}
+
+// fuzzShrinkString produces shorter strings.
+func fuzzShrinkString[T ~string](v T) []T {
+	if len(v) == 0 {
+		return nil
+	}
+
+	candidates := []T{""}
+	for n := len(v) / 2; n > 0; n /= 2 {
+		candidates = append(candidates, v[:len(v)-n], v[n:])
+	}
+	return candidates
+}
+
+// fuzzShrinkSlice produces shorter slices, and then slices with one
+// element shrunk, if there is a shrinker for the elements.
+func fuzzShrinkSlice[S ~[]E, E any](v S, shrinkElem func(E) []E) []S {
+	if len(v) == 0 {
+		return nil
+	}
+
+	candidates := []S{S{}}
+	for n := len(v) / 2; n > 0; n /= 2 {
+		for start := 0; start+n <= len(v); start += n {
+			candidate := make(S, 0, len(v)-n)
+			candidate = append(candidate, v[:start]...)
+			candidate = append(candidate, v[start+n:]...)
+			candidates = append(candidates, candidate)
+		}
+	}
 
-		if !(reference.NumEntries() == len(reference.AsSlice())) {
-			return errors.New("invariant violated: %var.NumEntries() == len(%var.AsSlice())")
+	if shrinkElem != nil {
+		for i, elem := range v {
+			for _, smaller := range shrinkElem(elem) {
+				candidate := make(S, len(v))
+				copy(candidate, v)
+				candidate[i] = smaller
+				candidates = append(candidates, candidate)
+			}
 		}
+	}
+	return candidates
+}
 
-		if !(reference.NumEntries() <= reference.MessageLimit()) {
-			return errors.New("invariant violated: %var.NumEntries() <= %var.MessageLimit()")
+// fuzzShrinkMap produces maps with fewer entries, and then maps with
+// one value shrunk, if there is a shrinker for the values.
+func fuzzShrinkMap[M ~map[K]V, K comparable, V any](v M, shrinkValue func(V) []V) []M {
+	if len(v) == 0 {
+		return nil
+	}
+
+	candidates := []M{M{}}
+	for key := range v {
+		candidate := make(M, len(v)-1)
+		for k, value := range v {
+			if k != key {
+				candidate[k] = value
+			}
 		}
+		candidates = append(candidates, candidate)
+	}
 
+	if shrinkValue != nil {
+		for key, value := range v {
+			for _, smaller := range shrinkValue(value) {
+				candidate := make(M, len(v))
+				for k, value := range v {
+					candidate[k] = value
+				}
+				candidate[key] = smaller
+				candidates = append(candidates, candidate)
+			}
+		}
 	}
+	return candidates
+}
 
-	return nil
+// fuzzReplaceArg copies a list of arguments, replacing one of them.
+func fuzzReplaceArg(args []interface{}, i int, v interface{}) []interface{} {
+	replaced := make([]interface{}, len(args))
+	copy(replaced, args)
+	replaced[i] = v
+	return replaced
 }
@@ -5,10 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/scanner"
+	"go/token"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
+	"unicode/utf8"
 
 	goimports "golang.org/x/tools/imports"
 )
@@ -32,9 +36,17 @@ type CodeGenOptions struct {
 	// Avoid generating the FuzzTest...(..., *testing.T) function.
 	NoTestCase bool
 
+	// Avoid generating the Fuzz...Native(*testing.F, ...)
+	// function.
+	NoNative bool
+
 	// Avoid generating the Fuzz...(..., *rand.Rand, uint)
-	// function. This implies NoTestCase.
+	// function. This implies NoTestCase and NoNative.
 	NoDefaultFuzz bool
+
+	// Only generate the Fuzz...Invariants(..., *rand.Rand, uint)
+	// function.
+	InvariantsOnly bool
 }
 
 // Fuzzer is a pair of an interface declaration and a description of
@@ -43,315 +55,2959 @@ type Fuzzer struct {
 	Name    string
 	Methods []Function
 	Wanted  WantedFuzzer
+
+	// The interface type. For an instantiation of a generic
+	// interface this includes the type arguments.
+	Type Type
+
+	// The type arguments, if this is an instantiation of a generic
+	// interface.
+	TypeArgs []Type
+
+	// The environment to resolve types in. This may be nil, in
+	// which case types are compared by their string rendition.
+	Env *TypeEnv
+
+	// The expression for the size of generated values, in the
+	// generated code. If empty, the maximum size is used.
+	size string
 }
 
 var (
 	// Default generators for builtin types. If there is no entry
 	// for the desired type, an error is signalled.
-	defaultGenerators = map[string]string{
-		"bool":       "rand.Intn(2) == 0",
-		"byte":       "byte(rand.Uint32())",
-		"complex64":  "complex(float32(rand.NormFloat64()), float32(rand.NormFloat64()))",
-		"complex128": "complex(rand.NormFloat64(), rand.NormFloat64())",
-		"float32":    "float32(rand.NormFloat64())",
-		"float64":    "rand.NormFloat64()",
-		"int":        "rand.Int()",
-		"int8":       "int8(rand.Int())",
-		"int16":      "int16(rand.Int())",
-		"int32":      "rand.Int31()",
-		"int64":      "rand.Int63()",
-		"rune":       "rune(rand.Int31())",
-		"uint":       "uint(rand.Uint32())",
-		"uint8":      "uint8(rand.Uint32())",
-		"uint16":     "uint16(rand.Uint32())",
-		"uint32":     "rand.Uint32()",
-		"uint64":     "(uint64(rand.Uint32()) << 32) | uint64(rand.Uint32())",
+	defaultGenerators = map[string]defaultGenerator{
+		"bool": {Uniform: "rand.Intn(2) == 0"},
+		"byte": {Uniform: "byte(rand.Uint32())", Edges: []string{"0", "1", "math.MaxUint8"}},
+		"complex64": {
+			Uniform: "complex(float32(rand.NormFloat64()), float32(rand.NormFloat64()))",
+			Edges:   []string{"0", "complex64(complex(math.Inf(1), 0))", "complex64(complex(math.NaN(), 0))"},
+		},
+		"complex128": {
+			Uniform: "complex(rand.NormFloat64(), rand.NormFloat64())",
+			Edges:   []string{"0", "complex(math.Inf(1), 0)", "complex(math.NaN(), 0)"},
+		},
+		"float32": {
+			Uniform: "float32(rand.NormFloat64())",
+			Edges: []string{"0", "float32(math.Copysign(0, -1))", "1", "-1", "math.SmallestNonzeroFloat32", "math.MaxFloat32", "-math.MaxFloat32",
+				"float32(math.Inf(1))", "float32(math.Inf(-1))", "float32(math.NaN())"},
+		},
+		"float64": {
+			Uniform: "rand.NormFloat64()",
+			Edges: []string{"0", "math.Copysign(0, -1)", "1", "-1", "math.SmallestNonzeroFloat64", "math.MaxFloat64", "-math.MaxFloat64",
+				"math.Inf(1)", "math.Inf(-1)", "math.NaN()"},
+		},
+		"int":    {Uniform: "rand.Int()", Edges: []string{"0", "1", "-1", "math.MaxInt", "math.MinInt"}},
+		"int8":   {Uniform: "int8(rand.Int())", Edges: []string{"0", "1", "-1", "math.MaxInt8", "math.MinInt8"}},
+		"int16":  {Uniform: "int16(rand.Int())", Edges: []string{"0", "1", "-1", "math.MaxInt16", "math.MinInt16"}},
+		"int32":  {Uniform: "rand.Int31()", Edges: []string{"0", "1", "-1", "math.MaxInt32", "math.MinInt32"}},
+		"int64":  {Uniform: "rand.Int63()", Edges: []string{"0", "1", "-1", "math.MaxInt64", "math.MinInt64"}},
+		"rune":   {Uniform: "rune(rand.Int31())", Edges: []string{"0", "1", "-1", "math.MaxInt32", "math.MinInt32"}},
+		"uint":   {Uniform: "uint(rand.Uint32())", Edges: []string{"0", "1", "math.MaxUint"}},
+		"uint8":  {Uniform: "uint8(rand.Uint32())", Edges: []string{"0", "1", "math.MaxUint8"}},
+		"uint16": {Uniform: "uint16(rand.Uint32())", Edges: []string{"0", "1", "math.MaxUint16"}},
+		"uint32": {Uniform: "rand.Uint32()", Edges: []string{"0", "1", "math.MaxUint32"}},
+		"uint64": {Uniform: "rand.Uint64()", Edges: []string{"0", "1", "math.MaxUint64"}},
 	}
 
 	// Default comparisons for builtin types. If there is no entry
 	// for the desired type, 'fallbackComparison' is used.
 	defaultComparisons = map[string]string{
-		"error": "((%s == nil) == (%s == nil))",
+		"error":      "((%s == nil) == (%s == nil))",
+		"complex64":  floatComparison,
+		"complex128": floatComparison,
+		"float32":    floatComparison,
+		"float64":    floatComparison,
+	}
+
+	// Comparison for floating-point types, in which NaN is equal to
+	// itself, as NaN is a boundary value which is generated.
+	floatComparison = "(%[1]s == %[2]s || %[1]s != %[1]s && %[2]s != %[2]s)"
+
+	// Default shrinkers for builtin types, which are generic
+	// functions in shrinkersCode. If there is no entry for the
+	// desired type, values are not shrunk.
+	defaultShrinkers = map[string]string{
+		"bool":       "fuzzShrinkBool",
+		"byte":       "fuzzShrinkInteger",
+		"complex64":  "fuzzShrinkComplex",
+		"complex128": "fuzzShrinkComplex",
+		"float32":    "fuzzShrinkFloat",
+		"float64":    "fuzzShrinkFloat",
+		"int":        "fuzzShrinkInteger",
+		"int8":       "fuzzShrinkInteger",
+		"int16":      "fuzzShrinkInteger",
+		"int32":      "fuzzShrinkInteger",
+		"int64":      "fuzzShrinkInteger",
+		"rune":       "fuzzShrinkInteger",
+		"string":     "fuzzShrinkString",
+		"uint":       "fuzzShrinkInteger",
+		"uint8":      "fuzzShrinkInteger",
+		"uint16":     "fuzzShrinkInteger",
+		"uint32":     "fuzzShrinkInteger",
+		"uint64":     "fuzzShrinkInteger",
 	}
 
 	// Fallback comparison if there is nothing in 'defaultComparisons'.
 	fallbackComparison = "reflect.DeepEqual(%s, %s)"
+
+	// Range of lengths of variadic arguments, if there is no
+	// "@variadic" line.
+	defaultVariadicLength = LengthRange{Min: 0, Max: 4}
+
+	// Range of the number of results generated in advance for a
+	// callback.
+	defaultCallbackLength = LengthRange{Min: 0, Max: 8}
+
+	// Maximum length of generated strings, slices, and maps, if
+	// there is no "@size" line.
+	defaultSize uint = 8
+
+	// Probability of generating a boundary value of a builtin type,
+	// or a boundary length of a string, slice, or map, if there is no
+	// "@edge cases" line.
+	defaultEdgeCases = 0.1
+
+	// Depth of nested slices, maps, and pointers past which they
+	// are left empty, so values of recursive types are finite.
+	maxGeneratorDepth = 4
+
+	// Probability of generating a value of an enumeration type which
+	// is not one of its constants, if there is no "@enum" line.
+	defaultOutOfRange = 0.1
 )
 
+// A default generator for a builtin type.
+type defaultGenerator struct {
+	// Expression for a uniformly-distributed value.
+	Uniform string
+
+	// Boundary values, one of which is generated instead with some
+	// probability.
+	Edges []string
+}
+
 // All of the templates take a Fuzzer as the argument.
 const (
 	// Template used by CodegenTestCase.
 	testCaseTemplate = `
 {{$name := .Name}}
+{{$type := toString .Type}}
 {{$args := argV .Wanted.Reference.Parameters}}
 
-func FuzzTest{{$name}}(makeTest func({{$args}}) {{$name}}, t *testing.T) {
-	rand := rand.New(rand.NewSource(0))
+func FuzzTest{{$name}}(makeTest func({{$args}}) {{$type}}, t *testing.T) {
+	seed := int64(0)
+	rand := rand.New(rand.NewSource(seed))
 
 	err := Fuzz{{$name}}(makeTest, rand, 100)
 
 	if err != nil {
+		var failure *{{$name}}FuzzFailure
+		if errors.As(err, &failure) {
+			failure.Seed, failure.HasSeed = seed, true
+
+			if dir := os.Getenv("FUZZ_REGRESSION_DIR"); dir != "" {
+				path, err := fuzz{{$name}}WriteRegression(dir, failure, makeTest)
+				if err != nil {
+					t.Logf("could not write regression test: %s", err)
+				} else {
+					t.Logf("wrote regression test to %s", path)
+				}
+			}
+		}
 		t.Error(err)
 	}
+}
+
+// fuzz{{$name}}WriteRegression writes a regression test for a failure
+// to a new file in a directory, and returns its path. The test
+// implementation is made by makeTest, which must be a named function.
+func fuzz{{$name}}WriteRegression(dir string, failure *{{$name}}FuzzFailure, makeTest interface{}) (string, error) {
+	fn := runtime.FuncForPC(reflect.ValueOf(makeTest).Pointer())
+	if fn == nil {
+		return "", errors.New("makeTest is not a named function")
+	}
+	makeName := strings.TrimPrefix(fn.Name(), reflect.TypeOf(*failure).PkgPath()+".")
+	if !token.IsIdentifier(makeName) {
+		return "", fmt.Errorf("makeTest is not a named function in this package: %s", fn.Name())
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(failure.Error()))
+	id := fmt.Sprintf("%08x", hash.Sum32())
+
+	code, err := failure.RegressionTest("Test{{$name}}Regression"+id, makeName)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "fuzz_"+strings.ToLower("{{$name}}")+"_regression_"+id+"_test.go")
+	return path, os.WriteFile(path, []byte(code), 0644)
+}`
+
+	// Template used by CodegenNative.
+	nativeTemplate = `
+{{$name := .Name}}
+{{$type := toString .Type}}
+{{$args := argV .Wanted.Reference.Parameters}}
+
+func Fuzz{{$name}}Native(f *testing.F, makeTest func({{$args}}) {{$type}}) {
+	// Start from the same operations as FuzzTest{{$name}}.
+	f.Add(fuzzNativeSeed())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Every operation takes at least one value from the
+		// input, and the run stops once it is used up.
+		maxops := uint(len(data) / 8)
+		if maxops > 100 {
+			maxops = 100
+		}
+		source := &fuzzByteSource{data: data}
+
+		err := fuzz{{$name}}(makeTest, rand.New(source), maxops, source.Exhausted)
+
+		if err != nil {
+			t.Error(err)
+		}
+	})
 }`
 
 	// Template used by CodegenWithDefaultReference
 	withDefaultReferenceTemplate = `
-{{$name  := .Name}}
-{{$args  := argV .Wanted.Reference.Parameters}}
-{{$decls := makeFunCalls . .Wanted.Reference .Wanted.Reference.Name "makeTest"}}
-{{$and   := eitherOr .Wanted.ReturnsValue "&" ""}}
+{{$fuzzer := .}}
+{{$name   := .Name}}
+{{$type   := toString .Type}}
+{{$args   := argV .Wanted.Reference.Parameters}}
+{{$gens   := makeArgGens . .Wanted.Reference false}}
+{{$decls  := makeFunCalls . .Wanted.Reference (referenceFunc .) "makeTest"}}
+{{$and    := eitherOr .Wanted.ReturnsValue "&" ""}}
+{{$invariants := regressionInvariants .}}
+
+func Fuzz{{$name}}(makeTest func ({{$args}}) {{$type}}, rand *rand.Rand, max uint) error {
+	return fuzz{{$name}}(makeTest, rand, max, nil)
+}
+
+// fuzz{{$name}} is Fuzz{{$name}}, stopping the run early once done
+// returns true, if it is not nil.
+func fuzz{{$name}}(makeTest func ({{$args}}) {{$type}}, rand *rand.Rand, max uint, done func() bool) error {
+{{$states := referenceGeneratorStates .}}{{if $states | ne ""}}	// Create initial state
+{{indent $states "\t"}}
+
+{{end}}{{if $gens | ne ""}}{{indent $gens "\t"}}
+
+{{end}}	// Create a fresh pair of implementations.
+	newImplementations := func() ({{$type}}, {{$type}}) {
+{{indent $decls "\t\t"}}
+
+		return {{$and}}{{expected .Wanted.Reference 0}}, {{actual .Wanted.Reference 0}}
+	}
+
+	reference, test := newImplementations()
+	ops, err := fuzz{{$name}}Run(reference, test, rand, max, done)
+	if err == nil {
+		return nil
+	}
+
+	// Replay a sequence of operations against a fresh pair of
+	// implementations.
+	replay := func(ops []fuzz{{$name}}Op) (int, error) {
+		reference, test := newImplementations()
+		var callbacks []fuzz{{$name}}Callback
+		for i, op := range ops {
+			if err := fuzz{{$name}}Step(reference, test, op, nil, &callbacks); err != nil {
+				return i + 1, err
+			}
+		}
+		return len(ops), nil
+	}
+
+	// Shrink the failing sequence of operations, and then their
+	// arguments.
+	ops, err = fuzz{{$name}}Shrink(ops, err, replay)
+	ops, err = fuzz{{$name}}ShrinkArgs(ops, err, replay)
+
+	err = fuzz{{$name}}Trace(ops, err)
+
+	var failure *{{$name}}FuzzFailure
+	if errors.As(err, &failure) {
+		failure.MaxOps = max
+		failure.MakeArgs = []interface{}{ {{- varV (arguments .Wanted.Reference) -}} }
+	}
+	return err
+}
+
+// RegressionTest renders a Go test file, with a test function named
+// testName, which replays the operations of a failure against a
+// reference implementation and one made by makeTest, which should be
+// the name of a function in the same package. If the arguments can't
+// all be written as Go literals, the test instead runs Fuzz{{$name}}
+// again with the same seed.
+func (f *{{$name}}FuzzFailure) RegressionTest(testName, makeTest string) (string, error) {
+	failureType := reflect.TypeOf(*f)
+	pkg := failureType.PkgPath()
+	imports := map[string]bool{"\"testing\"": true}{{range $spec := regressionImports . (print $decls $invariants)}}
+	imports[{{printf "%q" $spec}}] = true{{end}}
+
+	var body []string
+	makeArgs, ok := fuzzLiterals(f.MakeArgs, pkg, imports)
+	if ok && len(makeArgs) == {{len .Wanted.Reference.Parameters}} {
+		body = append(body, "makeTest := "+makeTest){{range $i, $arg := arguments .Wanted.Reference}}
+		body = append(body, fmt.Sprintf("{{$arg}} := %s", makeArgs[{{$i}}])){{end}}
+		body = append(body, {{printf "%q" $decls}}, "reference, test := {{$and}}{{expected .Wanted.Reference 0}}, {{actual .Wanted.Reference 0}}")
+
+		for _, call := range f.Trace {
+			var code string
+			code, ok = fuzz{{$name}}RegressionCall(call, pkg, imports)
+			if !ok {
+				break
+			}
+			body = append(body, "", "// "+call.String(), code{{if $invariants | ne ""}}, {{printf "%q" $invariants}}{{end}})
+		}
+	}
+
+	if !ok {
+		if !f.HasSeed {
+			return "", errors.New("the arguments can't be written as Go literals, and the seed is not known")
+		}
+		imports = map[string]bool{"\"testing\"": true, "\"math/rand\"": true}
+		body = []string{
+			fmt.Sprintf("err := Fuzz{{$name}}(%s, rand.New(rand.NewSource(%d)), %d)", makeTest, f.Seed, f.MaxOps),
+			"if err != nil {\n\tt.Fatal(err)\n}",
+		}
+	}
+
+	var specs []string
+	for spec := range imports {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+
+	code := "package " + strings.TrimSuffix(failureType.String(), "."+failureType.Name()) + "\n\n"
+	code = code + "import (\n" + strings.Join(specs, "\n") + "\n)\n\n"
+	code = code + "// " + testName + " replays a failure found by Fuzz{{$name}}:\n//\n"
+	for _, line := range strings.Split(f.Error(), "\n") {
+		code = code + "//\t" + line + "\n"
+	}
+	code = code + "func " + testName + "(t *testing.T) {\n" + strings.Join(body, "\n") + "\n}\n"
+
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+// fuzz{{$name}}RegressionCall renders a call as the code of a
+// regression test, which makes it on both implementations and checks
+// the results.
+func fuzz{{$name}}RegressionCall(call {{$name}}FuzzCall, pkg string, imports map[string]bool) (string, bool) {
+	var code string
+	switch call.Method { {{range $i, $function := .Methods}}{{$code := regressionCall $fuzzer $function}}{{if $code | ne ""}}
+	case {{printf "%q" $function.Name}}:
+		code = {{printf "%q" $code}}{{range $spec := regressionImports $fuzzer $code}}
+		imports[{{printf "%q" $spec}}] = true{{end}}{{end}}{{end}}
+	}
+	if code == "" {
+		return "", false
+	}
+
+	args, ok := fuzzLiterals(call.Args, pkg, imports)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(code, args...), true
+}
+
+// fuzz{{$name}}Shrink removes operations from a failing sequence for
+// as long as the sequence still fails, first in large chunks and then
+// one at a time. The replay function reports how many operations were
+// performed before the failure, if there was one.
+func fuzz{{$name}}Shrink(ops []fuzz{{$name}}Op, err error, replay func([]fuzz{{$name}}Op) (int, error)) ([]fuzz{{$name}}Op, error) {
+	size := len(ops) / 2
+	for size > 0 {
+		removed := false
+		for start := 0; start+size <= len(ops); {
+			candidate := make([]fuzz{{$name}}Op, 0, len(ops)-size)
+			candidate = append(candidate, ops[:start]...)
+			candidate = append(candidate, ops[start+size:]...)
+
+			if n, cerr := replay(candidate); cerr != nil {
+				ops, err = candidate[:n], cerr
+				removed = true
+			} else {
+				start += size
+			}
+		}
+
+		if size > 1 {
+			size = size / 2
+		} else if !removed {
+			size = 0
+		}
+	}
+
+	return ops, err
+}
+
+// fuzz{{$name}}ShrinkArgs shrinks the arguments of the operations in a
+// failing sequence, one at a time, for as long as the sequence still
+// fails. At most 1000 candidates are tried.
+func fuzz{{$name}}ShrinkArgs(ops []fuzz{{$name}}Op, err error, replay func([]fuzz{{$name}}Op) (int, error)) ([]fuzz{{$name}}Op, error) {
+	attempts := 0
+	for i := 0; i < len(ops); i++ {
+		for shrunk := true; shrunk; {
+			shrunk = false
+			for _, op := range fuzz{{$name}}ShrinkOp(ops[i]) {
+				if attempts++; attempts > 1000 {
+					return ops, err
+				}
+
+				candidate := make([]fuzz{{$name}}Op, len(ops))
+				copy(candidate, ops)
+				candidate[i] = op
+
+				if n, cerr := replay(candidate); cerr != nil {
+					ops, err = candidate[:n], cerr
+					shrunk = i < len(ops)
+					break
+				}
+			}
+		}
+	}
+
+	return ops, err
+}
+
+// fuzz{{$name}}ShrinkOp produces smaller variants of an operation, by
+// shrinking one of its arguments.
+func fuzz{{$name}}ShrinkOp(op fuzz{{$name}}Op) []fuzz{{$name}}Op {
+	var candidates []fuzz{{$name}}Op
+
+	switch op.method { {{range $i, $function := .Methods}}{{if hasShrinkers $fuzzer $function}}
+	case {{$i}}:{{range $j, $ty := $function.Parameters}}{{$shrinker := shrinker $fuzzer $function $j}}{{if $shrinker | ne ""}}
+		if v, ok := op.args[{{$j}}].({{argType $fuzzer $function $j}}); ok {
+			for _, smaller := range {{$shrinker}}(v) {
+				candidates = append(candidates, fuzz{{$name}}Op{method: op.method, args: fuzzReplaceArg(op.args, {{$j}}, smaller)})
+			}
+		}{{end}}{{end}}{{end}}{{end}}
+	}
+
+	return candidates
+}`
+
+	// Template used by CodegenFailure
+	failureTemplate = `
+{{$fuzzer := .}}
+{{$name   := .Name}}
+
+// {{$name}}FuzzFailure is an error found by the {{$name}} fuzz
+// tester, with the operations which led to it.
+type {{$name}}FuzzFailure struct {
+	// The seed the PRNG was created with, if HasSeed is true.
+	Seed    int64
+	HasSeed bool
+
+	// The maximum number of operations, and the arguments the
+	// implementations were made with, if known.
+	MaxOps   uint
+	MakeArgs []interface{}
+
+	// What went wrong: an inconsistent result or callback
+	// invocation, or a violated invariant.
+	Reason string
+
+	// The index in Trace of the operation which failed.
+	Index int
+
+	// The method called by the operation which failed, and its
+	// arguments. A callback is given as the results it returns.
+	Method string
+	Args   []interface{}
+
+	// The results returned by the reference and test
+	// implementations, by position. For an inconsistent callback
+	// these are the recorded invocations of the callback instead,
+	// and for a violated invariant they are nil.
+	Expected []interface{}
+	Actual   []interface{}
+
+	// The operations performed, up to and including the one which
+	// failed.
+	Trace []{{$name}}FuzzCall
+}
+
+func (f *{{$name}}FuzzFailure) Error() string {
+	msg := f.Reason
+	if f.Index >= 0 && f.Index < len(f.Trace) {
+		msg = msg + "\ncall: " + f.Trace[f.Index].String()
+	}
+	if f.Expected != nil || f.Actual != nil {
+		msg = msg + fmt.Sprintf("\nexpected: %s\nactual:   %s", fuzzValues(f.Expected), fuzzValues(f.Actual))
+	}
+	msg = msg + fmt.Sprintf("\ntrace of %d operations:", len(f.Trace))
+	for _, call := range f.Trace {
+		msg = msg + "\n\t" + call.String()
+	}
+	if f.HasSeed {
+		msg = msg + fmt.Sprintf("\nseed: %d", f.Seed)
+	}
+	return msg
+}
+
+// {{$name}}FuzzCall is a method call made by the {{$name}} fuzz tester.
+type {{$name}}FuzzCall struct {
+	// The method called.
+	Method string
+
+	// The arguments it was called with. A callback is given as the
+	// results it returns.
+	Args []interface{}
+
+	// A description of the call, with the names of the parameters.
+	description string
+}
+
+func (c {{$name}}FuzzCall) String() string {
+	return c.description
+}
+
+// fuzz{{$name}}Op is an operation performed by the {{$name}} fuzz
+// tester: a method, given by its index, and the arguments to call it
+// with.
+type fuzz{{$name}}Op struct {
+	method int
+	args   []interface{}
+}
+
+// fuzz{{$name}}Call describes an operation as a method call.
+func fuzz{{$name}}Call(op fuzz{{$name}}Op) {{$name}}FuzzCall {
+	call := {{$name}}FuzzCall{Args: op.args}
+
+	switch op.method { {{range $i, $function := .Methods}}
+	case {{$i}}:{{$unpack := unpackArgs $fuzzer $function false}}{{if $unpack | ne ""}}
+{{indent $unpack "\t\t"}}{{end}}
+		call.Method = {{printf "%q" $function.Name}}
+		call.description = {{describe $function}}{{end}}
+	}
+
+	return call
+}
+
+// fuzz{{$name}}Failure creates a failure for an operation.
+func fuzz{{$name}}Failure(op fuzz{{$name}}Op, reason string, expected, actual []interface{}) *{{$name}}FuzzFailure {
+	call := fuzz{{$name}}Call(op)
+	return &{{$name}}FuzzFailure{Reason: reason, Method: call.Method, Args: call.Args, Expected: expected, Actual: actual}
+}
 
-func Fuzz{{$name}}(makeTest func ({{$args}}) {{$name}}, rand *rand.Rand, max uint) error {
-{{indent $decls "\t"}}
+// fuzz{{$name}}Trace records the operations leading up to a failure in
+// it. The last of the operations is the one which failed.
+func fuzz{{$name}}Trace(ops []fuzz{{$name}}Op, err error) error {
+	var failure *{{$name}}FuzzFailure
+	if !errors.As(err, &failure) {
+		return err
+	}
 
-	return Fuzz{{$name}}With({{$and}}expected{{$name}}, actual{{$name}}, rand, max)
+	failure.Index = len(ops) - 1
+	failure.Trace = nil
+	for _, op := range ops {
+		failure.Trace = append(failure.Trace, fuzz{{$name}}Call(op))
+	}
+	return failure
 }`
 
 	// Template used by CodegenWithReference
 	withReferenceTemplate = `
 {{$fuzzer := .}}
 {{$name   := .Name}}
+{{$type   := toString .Type}}
+{{$count  := len .Methods}}
+
+// fuzz{{$name}}Pools holds values returned by earlier operations, to
+// be reused as the arguments of later ones.
+type fuzz{{$name}}Pools struct { {{- range $i, $reuse := reuses .}}
+	pool{{$i}} []{{toString $reuse.Type}}{{end}}
+}
+
+// fuzz{{$name}}Callback holds the recorded invocations of a callback
+// passed to both implementations by an operation. The implementations
+// may keep the callback and invoke it during later operations.
+type fuzz{{$name}}Callback struct {
+	method   string
+	expected *[][]interface{}
+	actual   *[][]interface{}
+}
+
+func Fuzz{{$name}}With(reference {{$type}}, test {{$type}}, rand *rand.Rand, maxops uint) error {
+	ops, err := fuzz{{$name}}Run(reference, test, rand, maxops, nil)
+	if err != nil {
+		return fuzz{{$name}}Trace(ops, err)
+	}
+	return nil
+}
+
+// fuzz{{$name}}Run performs random operations on both implementations,
+// returning the operations performed up to and including the first to
+// fail. If done is not nil, no more operations are performed once it
+// returns true.
+func fuzz{{$name}}Run(reference {{$type}}, test {{$type}}, rand *rand.Rand, maxops uint, done func() bool) ([]fuzz{{$name}}Op, error) {
+{{$states := generatorStates $fuzzer}}{{if $states | ne ""}}	// Create initial state
+{{indent $states "\t"}}
+
+{{end}}	pools := &fuzz{{$name}}Pools{}
+	var callbacks []fuzz{{$name}}Callback
+
+	var ops []fuzz{{$name}}Op
+	for i := uint(0); i < maxops; i++ {
+		if done != nil && done() {
+			break
+		}{{if usesSize $fuzzer}}
+
+		// Generated values grow over the run.
+		size := fuzzSize(i, maxops, {{maxSize $fuzzer}}){{end}}
+
+		// Pick a random number between 0 and the number of methods of the interface. Then generate the
+		// arguments for that method.
+
+		var op fuzz{{$name}}Op
+		actionToPerform := rand.Intn({{$count}})
+
+		switch actionToPerform { {{range $i, $function := .Methods}}
+		case {{$i}}:{{$gens := makeArgGens (sized $fuzzer) $function true}}{{if $gens | ne ""}}
+{{indent $gens "\t\t\t"}}
+{{end}}
+			op = fuzz{{$name}}Op{method: {{$i}}{{if len $function.Parameters | ne 0}}, args: []interface{}{ {{- opArgs $fuzzer $function -}} }{{end}}}{{end}}
+		}
+
+		// Then do that operation on both, and bail out on error. Simple!
+		ops = append(ops, op)
+		if err := fuzz{{$name}}Step(reference, test, op, pools, &callbacks); err != nil {
+			return ops, err
+		}
+	}
+
+	return ops, nil
+}
+
+// fuzz{{$name}}Step performs an operation on both implementations, and
+// checks the invariants still hold. If pools is not nil, the values it
+// returns are added to them. The callbacks passed by earlier
+// operations are checked too.
+func fuzz{{$name}}Step(reference {{$type}}, test {{$type}}, op fuzz{{$name}}Op, pools *fuzz{{$name}}Pools, callbacks *[]fuzz{{$name}}Callback) error {
+	if err := fuzz{{$name}}Apply(reference, test, op, pools, callbacks); err != nil {
+		return err
+	}{{range $i, $invariant := .Wanted.Invariants}}{{$expr := $invariant.Expression}}{{if $invariant.Both}}
+
+	if !({{sed $expr "%var" "reference"}}) {
+		return fuzz{{$name}}Failure(op, {{printf "%q" (print "invariant violated by reference implementation: " $expr)}}, nil, nil)
+	}
+	if !({{sed $expr "%var" "test"}}) {
+		return fuzz{{$name}}Failure(op, {{printf "%q" (print "invariant violated by test implementation: " $expr)}}, nil, nil)
+	}{{else}}
+
+	if !({{sed $expr "%var" "test"}}) {
+		return fuzz{{$name}}Failure(op, {{printf "%q" (print "invariant violated: " $expr)}}, nil, nil)
+	}{{end}}{{end}}
+
+	return nil
+}
+
+// fuzz{{$name}}Apply calls the method of an operation on both
+// implementations, and checks the results for discrepancies. If pools
+// is not nil, the values returned by the reference implementation are
+// added to them. The callbacks passed to the method are added to
+// callbacks, and all of them are checked, as the implementations may
+// keep a callback and invoke it later.
+func fuzz{{$name}}Apply(reference {{$type}}, test {{$type}}, op fuzz{{$name}}Op, pools *fuzz{{$name}}Pools, callbacks *[]fuzz{{$name}}Callback) error {
+	switch op.method { {{range $i, $function := .Methods}}
+	case {{$i}}:{{$unpack := unpackArgs $fuzzer $function true}}{{if $unpack | ne ""}}
+{{indent $unpack "\t\t"}}
+{{end}}
+		// Call the method on both implementations
+{{indent (makeFunCalls $fuzzer $function (printf "reference.%s" $function.Name) (printf "test.%s" $function.Name)) "\t\t"}}
+
+		// And check for discrepancies.{{range $j, $ty := $function.Returns}}{{$expected := expected $function $j}}{{$actual   := actual $function $j}}
+		if !{{printf (comparison $fuzzer $ty) $expected $actual}} {
+			return fuzz{{$name}}Failure(op, "inconsistent result in {{$function.Name}}", []interface{}{ {{- varV (expecteds $function) -}} }, []interface{}{ {{- varV (actuals $function) -}} })
+		}{{end}}{{range $j, $callback := callbacks $fuzzer $function}}
+		*callbacks = append(*callbacks, fuzz{{$name}}Callback{method: {{printf "%q" $function.Name}}, expected: &{{$callback}}ExpectedCalls, actual: &{{$callback}}ActualCalls}){{end}}{{$pool := poolResults $fuzzer $function}}{{if $pool | ne ""}}
+
+		// Keep the values to reuse.
+		if pools != nil {
+{{indent $pool "\t\t\t"}}
+		}{{end}}{{end}}
+	}{{if usesCallbacks $fuzzer}}
+
+	// Check the invocations of every callback passed so far.
+	for _, callback := range *callbacks {
+		if !reflect.DeepEqual(*callback.expected, *callback.actual) {
+			return fuzz{{$name}}Failure(op, "inconsistent callback invocations in "+callback.method, []interface{}{*callback.expected}, []interface{}{*callback.actual})
+		}
+	}{{end}}
+
+	return nil
+}`
+
+	// Template used by CodegenInvariants
+	invariantsTemplate = `
+{{$fuzzer := .}}
+{{$name   := .Name}}
+{{$type   := toString .Type}}
 {{$count  := len .Methods}}
-{{$state  := .Wanted.GeneratorState}}
 
-func Fuzz{{$name}}With(reference {{$name}}, test {{$name}}, rand *rand.Rand, maxops uint) error {
-{{if $state | eq ""}}{{else}}	// Create initial state
-	state := {{$state}}
+func Fuzz{{$name}}Invariants(test {{$type}}, rand *rand.Rand, maxops uint) error {
+{{$states := generatorStates $fuzzer}}{{if $states | ne ""}}	// Create initial state
+{{indent $states "\t"}}
+
+{{end}}	var ops []fuzz{{$name}}Op
+	for i := uint(0); i < maxops; i++ {{"{"}}{{if usesSize $fuzzer}}
+		// Generated values grow over the run.
+		size := fuzzSize(i, maxops, {{maxSize $fuzzer}})
+{{end}}
+		// Pick a random number between 0 and the number of methods of the interface. Then do that method,
+		// and check the invariants still hold.
+
+		var op fuzz{{$name}}Op
+		actionToPerform := rand.Intn({{$count}})
+
+		switch actionToPerform { {{range $i, $function := .Methods}}
+		case {{$i}}:
+			// Call the method on the implementation{{$gens := makeArgGens (sized $fuzzer) $function false}}{{if $gens | ne ""}}
+{{indent $gens "\t\t\t"}}
+{{end}}
+			op = fuzz{{$name}}Op{method: {{$i}}{{if len $function.Parameters | ne 0}}, args: []interface{}{ {{- opArgs $fuzzer $function -}} }{{end}}}
+{{indent (makeFunCalls $fuzzer $function (printf "test.%s" $function.Name) "") "\t\t\t"}}{{end}}
+		}
+		ops = append(ops, op){{range $i, $invariant := .Wanted.Invariants}}{{$expr := $invariant.Expression}}
+
+		if !({{sed $expr "%var" "test"}}) {
+			return fuzz{{$name}}Trace(ops, fuzz{{$name}}Failure(op, {{printf "%q" (print "invariant violated: " $expr)}}, nil, nil))
+		}{{end}}
+	}
+
+	return nil
+}`
+
+	// Helper functions for reporting failures, shared by all of the
+	// fuzzers in a file.
+	failuresCode = `// Failures
+
+// fuzzLiterals renders values as Go literals, for a regression test in
+// the package with the given path. Any other packages the literals
+// use are added to imports.
+func fuzzLiterals(values []interface{}, pkg string, imports map[string]bool) ([]interface{}, bool) {
+	var literals []interface{}
+	for i := range values {
+		literal, ok := fuzzLiteral(reflect.ValueOf(&values[i]).Elem(), pkg, imports)
+		if !ok {
+			return nil, false
+		}
+		literals = append(literals, literal)
+	}
+	return literals, true
+}
+
+// fuzzLiteral renders a value as a Go literal. Functions, channels,
+// non-nil pointers to anything but structs, and values with
+// unexported fields from other packages can't be rendered.
+func fuzzLiteral(v reflect.Value, pkg string, imports map[string]bool) (string, bool) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "nil", true
+		}
+		v = v.Elem()
+	}
+
+	ty, ok := fuzzTypeName(v.Type(), pkg, imports)
+	if !ok {
+		return "", false
+	}
+
+	// Constants are converted to their type, unless it is the
+	// default type of the constant.
+	convert := func(literal, def string) (string, bool) {
+		if ty == def {
+			return literal, true
+		}
+		return ty + "(" + literal + ")", true
+	}
+	float := func(f float64, bits int) (string, bool) {
+		switch {
+		case math.IsNaN(f):
+			imports["\"math\""] = true
+			return "math.NaN()", true
+		case math.IsInf(f, 0):
+			imports["\"math\""] = true
+			return fmt.Sprintf("math.Inf(%d)", int(math.Copysign(1, f))), true
+		case f == 0 && math.Signbit(f):
+			imports["\"math\""] = true
+			return "math.Copysign(0, -1)", true
+		}
+		return strconv.FormatFloat(f, 'g', -1, bits), true
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return convert(strconv.FormatBool(v.Bool()), "bool")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return convert(strconv.FormatInt(v.Int(), 10), "int")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return convert(strconv.FormatUint(v.Uint(), 10), "")
+	case reflect.Float32, reflect.Float64:
+		f, ok := float(v.Float(), v.Type().Bits())
+		if !ok {
+			return "", false
+		}
+		return convert(f, "")
+	case reflect.Complex64, reflect.Complex128:
+		re, reok := float(real(v.Complex()), v.Type().Bits()/2)
+		im, imok := float(imag(v.Complex()), v.Type().Bits()/2)
+		if !(reok && imok) {
+			return "", false
+		}
+		return convert("complex("+re+", "+im+")", "complex128")
+	case reflect.String:
+		return convert(strconv.Quote(v.String()), "string")
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "(" + ty + ")(nil)", true
+		}
+		var elems []string
+		for i := 0; i < v.Len(); i++ {
+			elem, ok := fuzzLiteral(v.Index(i), pkg, imports)
+			if !ok {
+				return "", false
+			}
+			elems = append(elems, elem)
+		}
+		return ty + "{" + strings.Join(elems, ", ") + "}", true
+	case reflect.Map:
+		if v.IsNil() {
+			return "(" + ty + ")(nil)", true
+		}
+		var entries []string
+		iter := v.MapRange()
+		for iter.Next() {
+			key, keyok := fuzzLiteral(iter.Key(), pkg, imports)
+			value, valueok := fuzzLiteral(iter.Value(), pkg, imports)
+			if !(keyok && valueok) {
+				return "", false
+			}
+			entries = append(entries, key+": "+value)
+		}
+		sort.Strings(entries)
+		return ty + "{" + strings.Join(entries, ", ") + "}", true
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "(" + ty + ")(nil)", true
+		}
+		if v.Elem().Kind() != reflect.Struct {
+			return "", false
+		}
+		elem, ok := fuzzLiteral(v.Elem(), pkg, imports)
+		return "&" + elem, ok
+	case reflect.Struct:
+		var fields []string
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" && field.PkgPath != pkg {
+				return "", false
+			}
+			value, ok := fuzzLiteral(v.Field(i), pkg, imports)
+			if !ok {
+				return "", false
+			}
+			fields = append(fields, field.Name+": "+value)
+		}
+		return ty + "{" + strings.Join(fields, ", ") + "}", true
+	}
+
+	return "", false
+}
+
+// fuzzTypeName renders a type for a regression test in the package
+// with the given path. Types from other packages are qualified by the
+// package name, and the packages added to imports.
+func fuzzTypeName(ty reflect.Type, pkg string, imports map[string]bool) (string, bool) {
+	if ty.Name() != "" {
+		switch {
+		case strings.Contains(ty.Name(), "["):
+			// The type arguments of a generic type are not
+			// qualified in the same way.
+			return "", false
+		case ty.PkgPath() == "" || ty.PkgPath() == pkg:
+			return ty.Name(), true
+		}
+		imports[strconv.Quote(ty.PkgPath())] = true
+		return ty.String(), true
+	}
+
+	switch ty.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Ptr:
+		elem, ok := fuzzTypeName(ty.Elem(), pkg, imports)
+		switch ty.Kind() {
+		case reflect.Slice:
+			return "[]" + elem, ok
+		case reflect.Array:
+			return fmt.Sprintf("[%d]%s", ty.Len(), elem), ok
+		}
+		return "*" + elem, ok
+	case reflect.Map:
+		key, keyok := fuzzTypeName(ty.Key(), pkg, imports)
+		elem, elemok := fuzzTypeName(ty.Elem(), pkg, imports)
+		return "map[" + key + "]" + elem, keyok && elemok
+	case reflect.Interface:
+		if ty.NumMethod() == 0 {
+			return "interface{}", true
+		}
+	}
+
+	return "", false
+}
+
+// fuzzValues renders a list of values, separated by commas.
+func fuzzValues(values []interface{}) string {
+	var strs []string
+	for _, value := range values {
+		strs = append(strs, fmt.Sprintf("%v", value))
+	}
+	return strings.Join(strs, ", ")
+}`
+
+	// Helper functions for generating values, shared by all of the
+	// fuzzers in a file.
+	generatorsCode = `// Generators
+
+// fuzzSize gives the size of the values generated for the ith of
+// maxops operations, which grows linearly up to max.
+func fuzzSize(i, maxops uint, max int) int {
+	return int((uint(max)*(i+1) + maxops - 1) / maxops)
+}
+
+// fuzzEdge gives one of the boundary values with probability bias, and
+// the uniform value otherwise.
+func fuzzEdge[T any](rand *rand.Rand, bias float64, uniform T, edges ...T) T {
+	if rand.Float64() < bias {
+		return edges[rand.Intn(len(edges))]
+	}
+	return uniform
+}
+
+// fuzzContains checks if a value is one of a set.
+func fuzzContains[T comparable](values []T, v T) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzLength generates a length of at most size, which is zero or size
+// with probability bias.
+func fuzzLength(rand *rand.Rand, size int, bias float64) int {
+	if bias > 0 && rand.Float64() < bias {
+		return size * rand.Intn(2)
+	}
+	return rand.Intn(size + 1)
+}
+
+// fuzzLengthRange generates a length between min and max inclusive,
+// which is at most size more than min.
+func fuzzLengthRange(rand *rand.Rand, size, min, max int) int {
+	if max > min+size {
+		max = min + size
+	}
+	return min + rand.Intn(max-min+1)
+}
+
+// fuzzString generates a string of printable ASCII characters, of
+// length at most size.
+func fuzzString(rand *rand.Rand, size int, bias float64) string {
+	runes := make([]rune, fuzzLength(rand, size, bias))
+	for i := range runes {
+		runes[i] = rune(' ' + rand.Intn('~'-' '+1))
+	}
+	return string(runes)
+}
+
+// fuzzBytes generates a slice of bytes, of length at most size.
+func fuzzBytes(rand *rand.Rand, size int, bias float64) []byte {
+	bytes := make([]byte, fuzzLength(rand, size, bias))
+	rand.Read(bytes)
+	return bytes
+}`
+
+	// Helper functions for generating strings from regular
+	// expressions and grammars, shared by all of the fuzzers in a
+	// file.
+	patternsCode = `// Patterns
+
+// fuzzRule is a rule of a grammar, with the alternatives it may be
+// replaced by, and the one which soonest leaves no rules to replace.
+type fuzzRule struct {
+	Alternatives [][]fuzzSymbol
+	Shortest     int
+}
+
+// fuzzSymbol is a symbol in an alternative of a rule: another rule,
+// some text, or a regular expression.
+type fuzzSymbol struct {
+	Rule   string
+	Text   string
+	Regexp *syntax.Regexp
+}
+
+// fuzzParseRegexp parses a regular expression, which is known to be
+// valid.
+func fuzzParseRegexp(pattern string) *syntax.Regexp {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// fuzzRegexp generates a string matching a regular expression, with
+// at most size more repetitions than the minimum. Anchors and word
+// boundaries are ignored.
+func fuzzRegexp(rand *rand.Rand, size int, re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCharClass:
+		// The class is a list of inclusive ranges of runes, which
+		// are split around the surrogates (U+D800 to U+DFFF), as
+		// those are not runes on their own.
+		var ranges []rune
+		for i := 0; i < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if lo < 0xD800 {
+				ranges = append(ranges, lo, hi)
+				if hi > 0xD7FF {
+					ranges[len(ranges)-1] = 0xD7FF
+				}
+			}
+			if hi > 0xDFFF {
+				if lo < 0xE000 {
+					lo = 0xE000
+				}
+				ranges = append(ranges, lo, hi)
+			}
+		}
+		count := 0
+		for i := 0; i < len(ranges); i += 2 {
+			count += int(ranges[i+1]-ranges[i]) + 1
+		}
+		if count == 0 {
+			return ""
+		}
+		n, i := rand.Intn(count), 0
+		for n > int(ranges[i+1]-ranges[i]) {
+			n -= int(ranges[i+1]-ranges[i]) + 1
+			i += 2
+		}
+		return string(ranges[i] + rune(n))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return string(rune(' ' + rand.Intn('~'-' '+1)))
+	case syntax.OpCapture:
+		return fuzzRegexp(rand, size, re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		min, max := re.Min, re.Max
+		switch re.Op {
+		case syntax.OpStar:
+			min, max = 0, -1
+		case syntax.OpPlus:
+			min, max = 1, -1
+		case syntax.OpQuest:
+			min, max = 0, 1
+		}
+		if max < 0 || max > min+size {
+			max = min + size
+		}
+		var s string
+		for n := min + rand.Intn(max-min+1); n > 0; n-- {
+			s += fuzzRegexp(rand, size, re.Sub[0])
+		}
+		return s
+	case syntax.OpConcat:
+		var s string
+		for _, sub := range re.Sub {
+			s += fuzzRegexp(rand, size, sub)
+		}
+		return s
+	case syntax.OpAlternate:
+		return fuzzRegexp(rand, size, re.Sub[rand.Intn(len(re.Sub))])
+	}
+	return ""
+}
+
+// fuzzGrammar generates a string derived from a rule of a grammar. The
+// alternatives are picked at random to a depth of size, and for at most
+// size rules in all, and then the shortest are picked.
+func fuzzGrammar(rand *rand.Rand, size int, rules map[string]fuzzRule, rule string) string {
+	budget := size
+	return fuzzGrammarDepth(rand, size, size, &budget, rules, rule)
+}
+
+// fuzzGrammarDepth generates a string derived from a rule of a grammar,
+// picking alternatives at random to the given depth, while the budget
+// of random picks shared by the whole derivation lasts.
+func fuzzGrammarDepth(rand *rand.Rand, size, depth int, budget *int, rules map[string]fuzzRule, rule string) string {
+	alternatives := rules[rule].Alternatives
+	alternative := alternatives[rules[rule].Shortest]
+	if depth > 0 && *budget > 0 {
+		*budget--
+		alternative = alternatives[rand.Intn(len(alternatives))]
+	}
+
+	var s string
+	for _, symbol := range alternative {
+		switch {
+		case symbol.Rule != "":
+			s += fuzzGrammarDepth(rand, size, depth-1, budget, rules, symbol.Rule)
+		case symbol.Regexp != nil:
+			s += fuzzRegexp(rand, size, symbol.Regexp)
+		default:
+			s += symbol.Text
+		}
+	}
+	return s
+}`
+
+	// Helper functions for copying arguments, shared by all of the
+	// fuzzers in a file.
+	copyingCode = `// Copying
+
+// fuzzCopy makes a deep copy of a value, so that an implementation
+// which modifies its arguments doesn't change those of the other
+// implementation, or of a later replay. Unexported fields of structs
+// are copied shallowly, and pointers to the same value are copied to
+// pointers to the same copy.
+func fuzzCopy[T any](v T) T {
+	copied, _ := fuzzCopyValue(reflect.ValueOf(&v).Elem(), map[uintptr]reflect.Value{}).Interface().(T)
+	return copied
+}
+
+func fuzzCopyValue(v reflect.Value, seen map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		if copied, ok := seen[v.Pointer()]; ok && copied.Type() == v.Type() {
+			return copied
+		}
+		copied := reflect.New(v.Type().Elem())
+		seen[v.Pointer()] = copied
+		copied.Elem().Set(fuzzCopyValue(v.Elem(), seen))
+		return copied
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(fuzzCopyValue(v.Index(i), seen))
+		}
+		return copied
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			copied.SetMapIndex(iter.Key(), fuzzCopyValue(iter.Value(), seen))
+		}
+		return copied
+
+	case reflect.Array:
+		copied := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(fuzzCopyValue(v.Index(i), seen))
+		}
+		return copied
+
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if copied.Field(i).CanSet() {
+				copied.Field(i).Set(fuzzCopyValue(v.Field(i), seen))
+			}
+		}
+		return copied
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(fuzzCopyValue(v.Elem(), seen))
+		return copied
+	}
+
+	return v
+}`
+
+	// Helper types for native fuzzing, shared by all of the fuzzers
+	// in a file.
+	nativeCode = `// Native fuzzing
+
+// fuzzByteSource is a rand.Source which takes its values from the
+// input of a native fuzz test, 8 bytes at a time. Once the input runs
+// out it gives zero, and is exhausted.
+type fuzzByteSource struct {
+	data      []byte
+	exhausted bool
+}
+
+func (s *fuzzByteSource) Uint64() uint64 {
+	if len(s.data) == 0 {
+		s.exhausted = true
+	}
+
+	var v uint64
+	for i := 0; i < 8 && len(s.data) > 0; i++ {
+		v = v<<8 | uint64(s.data[0])
+		s.data = s.data[1:]
+	}
+	return v
+}
+
+func (s *fuzzByteSource) Int63() int64 {
+	return int64(s.Uint64() &^ (1 << 63))
+}
+
+func (s *fuzzByteSource) Seed(int64) {}
+
+// Exhausted reports whether a value has been asked for after the
+// input ran out.
+func (s *fuzzByteSource) Exhausted() bool {
+	return s.exhausted
+}
+
+// fuzzNativeSeed encodes the values of the source used by the
+// FuzzTest functions as the input of a native fuzz test, so that a
+// fuzzByteSource gives the same values. Enough are encoded for 100
+// operations with several arguments each.
+func fuzzNativeSeed() []byte {
+	source := rand.NewSource(0).(rand.Source64)
+	seed := make([]byte, 8*4096)
+	for i := 0; i < len(seed); i += 8 {
+		binary.BigEndian.PutUint64(seed[i:], source.Uint64())
+	}
+	return seed
+}`
+
+	// Helper functions for shrinking values, shared by all of the
+	// fuzzers in a file.
+	shrinkersCode = `// Shrinking
+
+// fuzzShrinkBool shrinks true to false.
+func fuzzShrinkBool[T ~bool](v T) []T {
+	if !v {
+		return nil
+	}
+	return []T{false}
+}
+
+// fuzzShrinkInteger produces integers closer to zero.
+func fuzzShrinkInteger[T ~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr](v T) []T {
+	if v == 0 {
+		return nil
+	}
+
+	candidates := []T{0}
+	for delta := v / 2; delta != 0; delta /= 2 {
+		candidates = append(candidates, v-delta)
+	}
+	return candidates
+}
+
+// fuzzShrinkFloat produces floats closer to zero, without a fractional
+// part.
+func fuzzShrinkFloat[T ~float32 | ~float64](v T) []T {
+	if v == 0 {
+		return nil
+	}
+	if v != v {
+		return []T{0}
+	}
+
+	candidates := []T{0}
+	if trunc := T(math.Trunc(float64(v))); trunc != v {
+		candidates = append(candidates, trunc)
+	} else if half := T(math.Trunc(float64(v / 2))); half != v {
+		candidates = append(candidates, half)
+	}
+	return candidates
+}
+
+// fuzzShrinkComplex shrinks complex numbers to zero.
+func fuzzShrinkComplex[T ~complex64 | ~complex128](v T) []T {
+	if v == 0 {
+		return nil
+	}
+	return []T{0}
+}
+
+// fuzzShrinkString produces shorter strings.
+func fuzzShrinkString[T ~string](v T) []T {
+	if len(v) == 0 {
+		return nil
+	}
+
+	candidates := []T{""}
+	for n := len(v) / 2; n > 0; n /= 2 {
+		candidates = append(candidates, v[:len(v)-n], v[n:])
+	}
+	return candidates
+}
+
+// fuzzShrinkSlice produces shorter slices, and then slices with one
+// element shrunk, if there is a shrinker for the elements.
+func fuzzShrinkSlice[S ~[]E, E any](v S, shrinkElem func(E) []E) []S {
+	if len(v) == 0 {
+		return nil
+	}
+
+	candidates := []S{S{}}
+	for n := len(v) / 2; n > 0; n /= 2 {
+		for start := 0; start+n <= len(v); start += n {
+			candidate := make(S, 0, len(v)-n)
+			candidate = append(candidate, v[:start]...)
+			candidate = append(candidate, v[start+n:]...)
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if shrinkElem != nil {
+		for i, elem := range v {
+			for _, smaller := range shrinkElem(elem) {
+				candidate := make(S, len(v))
+				copy(candidate, v)
+				candidate[i] = smaller
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates
+}
+
+// fuzzShrinkMap produces maps with fewer entries, and then maps with
+// one value shrunk, if there is a shrinker for the values.
+func fuzzShrinkMap[M ~map[K]V, K comparable, V any](v M, shrinkValue func(V) []V) []M {
+	if len(v) == 0 {
+		return nil
+	}
+
+	candidates := []M{M{}}
+	for key := range v {
+		candidate := make(M, len(v)-1)
+		for k, value := range v {
+			if k != key {
+				candidate[k] = value
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	if shrinkValue != nil {
+		for key, value := range v {
+			for _, smaller := range shrinkValue(value) {
+				candidate := make(M, len(v))
+				for k, value := range v {
+					candidate[k] = value
+				}
+				candidate[key] = smaller
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates
+}
+
+// fuzzReplaceArg copies a list of arguments, replacing one of them.
+func fuzzReplaceArg(args []interface{}, i int, v interface{}) []interface{} {
+	replaced := make([]interface{}, len(args))
+	copy(replaced, args)
+	replaced[i] = v
+	return replaced
+}`
+
+	// Template used by MakeArgumentGenerators.
+	argumentsTemplate = `
+{{$fuzzer    := . }}
+{{$function  := function ""}}
+{{$arguments := arguments $function}}
+
+{{if len $arguments | ne 0}}{{if len (callbacks $fuzzer $function) | ne (len $arguments)}}
+var ({{range $i, $ty := $function.Parameters}}{{if not (isCallback $fuzzer $function $i)}}
+	{{argument $function $i}} {{toString $ty}}{{end}}{{end}}
+)
+{{end}}{{range $i, $ty := $function.Parameters}}
+{{makeArgGen $fuzzer $function $i (pools "")}}{{end}}{{end}}`
+
+	// Template used by MakeFunctionCalls.
+	functionCallTemplate = `
+{{$fuzzer       := . }}
+{{$function     := function ""}}
+{{$expecteds    := expecteds $function}}
+{{$actuals      := actuals $function}}
+{{$expectedFunc := expectedFunc ""}}
+{{$actualFunc   := actualFunc ""}}
+
+{{makeCallbacks $fuzzer $function (sides "")}}
+
+{{if $actualFunc | ne ""}}{{copyArgs $fuzzer $function}}{{end}}
+
+{{if $actualFunc | eq ""}}
+{{$expectedFunc}}({{callArgs $fuzzer $function ""}})
+{{else if len $expecteds | eq 0}}
+{{$expectedFunc}}({{callArgs $fuzzer $function "Expected"}})
+{{$actualFunc}}({{callArgs $fuzzer $function "Actual"}})
+{{else}}
+{{varV $expecteds}} := {{$expectedFunc}}({{callArgs $fuzzer $function "Expected"}})
+{{varV $actuals}} := {{$actualFunc}}({{callArgs $fuzzer $function "Actual"}})
+{{end}}`
+)
+
+/// ENTRY POINT
+
+// CodeGen generate code for the fuzzers.
+func CodeGen(options CodeGenOptions, imports []*ast.ImportSpec, fuzzers []Fuzzer) (string, []error) {
+	var code string
+	var errs []error
+
+	if options.Complete {
+		code = generatePreamble(options.PackageName, imports)
+	}
+
+	codeGenErr := func(fuzzer Fuzzer, err error) error {
+		return fmt.Errorf("error occurred whilst generating code for '%s': %s", fuzzer.Name, err)
+	}
+
+	// Whether any fuzzers use the generator, pattern, failure,
+	// copying, native fuzzing, and shrinking helpers.
+	generators := false
+	patterns := false
+	failures := false
+	copying := false
+	native := false
+	shrinking := false
+
+	for _, fuzzer := range fuzzers {
+		code = code + "// " + fuzzer.Name + "\n\n"
+
+		// ...FuzzFailure
+		generated, err := CodegenFailure(fuzzer)
+		if err != nil {
+			errs = append(errs, codeGenErr(fuzzer, err))
+			continue
+		}
+		code = code + generated + "\n\n"
+		failures = true
+
+		// Fuzz...Invariants(... *rand.Rand, uint) only
+		if options.InvariantsOnly {
+			generated, err := CodegenInvariants(fuzzer)
+			if err != nil {
+				errs = append(errs, codeGenErr(fuzzer, err))
+				continue
+			}
+			code = code + generated + "\n\n"
+			generators = true
+			patterns = patterns || usesPatterns(fuzzer)
+			continue
+		}
+
+		// Without a reference implementation, only the functions
+		// which are given one can be generated.
+		noReference := fuzzer.Wanted.Reference.Name == ""
+
+		// FuzzTest...(... *testing.T)
+		if !(options.NoTestCase || options.NoDefaultFuzz || noReference) {
+			generated, err := CodegenTestCase(fuzzer)
+			if err != nil {
+				errs = append(errs, codeGenErr(fuzzer, err))
+				continue
+			}
+			code = code + generated + "\n\n"
+		}
+
+		// Fuzz...Native(*testing.F, ...)
+		if !(options.NoNative || options.NoDefaultFuzz || noReference) {
+			generated, err := CodegenNative(fuzzer)
+			if err != nil {
+				errs = append(errs, codeGenErr(fuzzer, err))
+				continue
+			}
+			code = code + generated + "\n\n"
+			native = true
+		}
+
+		// Fuzz...(... *rand.Rand, uint)
+		if !(options.NoDefaultFuzz || noReference) {
+			generated, err := CodegenWithDefaultReference(fuzzer)
+			if err != nil {
+				errs = append(errs, codeGenErr(fuzzer, err))
+				continue
+			}
+			code = code + generated + "\n\n"
+			shrinking = true
+		}
+
+		generated, err = CodegenWithReference(fuzzer)
+		if err != nil {
+			errs = append(errs, codeGenErr(fuzzer, err))
+			continue
+		}
+		code = code + generated + "\n\n"
+		generators = true
+		patterns = patterns || usesPatterns(fuzzer)
+		copying = true
+
+		// Fuzz...Invariants(... *rand.Rand, uint)
+		if len(fuzzer.Wanted.Invariants) > 0 {
+			generated, err := CodegenInvariants(fuzzer)
+			if err != nil {
+				errs = append(errs, codeGenErr(fuzzer, err))
+				continue
+			}
+			code = code + generated + "\n\n"
+		}
+	}
+
+	if generators {
+		code = code + generatorsCode + "\n\n"
+	}
+	if patterns {
+		code = code + patternsCode + "\n\n"
+	}
+	if failures {
+		code = code + failuresCode + "\n\n"
+	}
+	if copying {
+		code = code + copyingCode + "\n\n"
+	}
+	if native {
+		code = code + nativeCode + "\n\n"
+	}
+	if shrinking {
+		code = code + shrinkersCode + "\n\n"
+	}
+
+	code, err := fixImports(options, code)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return code, errs
+}
+
+// Generates the header for a complete source file: the package name
+// and the imports. These imports may be both overzealous (all imports
+// from the source file are copied across) and incomplete (imports the
+// generated functions pull in aren't added), so the FixImports
+// function must be called after the full code has been generated to
+// fix this up. The generated functions take a "math/rand" PRNG, so
+// that is imported too, or else FixImports may pick "math/rand/v2".
+func generatePreamble(packagename string, imports []*ast.ImportSpec) string {
+	preamble := "package " + packagename + "\n\n"
+
+	random := true
+	for _, iport := range imports {
+		preamble = preamble + generateImport(iport) + "\n"
+		if iport.Path.Value == `"math/rand"` || iport.Path.Value == `"math/rand/v2"` || (iport.Name != nil && iport.Name.Name == "rand") {
+			random = false
+		}
+	}
+	if random {
+		preamble = preamble + `import "math/rand"` + "\n"
+	}
+
+	return preamble + "\n"
+}
+
+// Generates an import statement from an *ast.ImportSpec.
+func generateImport(iport *ast.ImportSpec) string {
+	if iport.Name != nil {
+		return "import " + iport.Name.Name + " " + iport.Path.Value
+	}
+	return "import " + iport.Path.Value
+}
+
+// Adds and removes imports with 'goimports'.
+func fixImports(options CodeGenOptions, code string) (string, error) {
+	if options.Complete {
+		cbytes, err := goimports.Process(options.Filename, []byte(code), nil)
+		return string(cbytes), err
+	}
+	return code, nil
+}
+
+/// MAIN CODE GENERATORS
+
+// CodegenTestCase generates a function which can be used as a test
+// case when given an implementation to test.
+//
+// For an interface named `Store` with a generating function that
+// takes a single `int`, the generated function signature looks like
+// this:
+//
+//   FuzzTestStore(makeTest (func(int) Store), t *testing.T)
+//
+// This test case will call `FuzzStore` (see
+// CodegenWithDefaultReference) with a max number of 100 operations.
+func CodegenTestCase(fuzzer Fuzzer) (string, error) {
+	return runTemplate("testCase", testCaseTemplate, fuzzer)
+}
+
+// CodegenNative generates a function which can be used as a native
+// Go fuzz test when given an implementation to test, with the
+// operations and their arguments decoded from the fuzzer's input.
+//
+// For an interface named `Store` with a generating function that
+// takes a single `int`, the generated function signature looks like
+// this:
+//
+//   FuzzStoreNative(f *testing.F, makeTest (func(int) Store))
+//
+// This will call `FuzzStore` (see CodegenWithDefaultReference) with a
+// max number of operations depending on the length of the input, up
+// to 100.
+func CodegenNative(fuzzer Fuzzer) (string, error) {
+	return runTemplate("native", nativeTemplate, fuzzer)
+}
+
+// CodegenWithDefaultReference generates a function which will compare
+// a supplied implementation of the interface against the reference,
+// by performing a sequence of random operations.
+//
+// For an interface named `Store` with a generating function that
+// takes a single `int`, the generated function signature looks like
+// this:
+//
+//   FuzzStore(makeTest (func(int) Store), rand *rand.Rand, maxops uint) error
+//
+// This function will call `FuzzStoreWith` (see CodegenWithReference)
+// with the default reference.
+func CodegenWithDefaultReference(fuzzer Fuzzer) (string, error) {
+	return runTemplate("withDefaultReference", withDefaultReferenceTemplate, fuzzer)
+}
+
+// CodegenFailure generates the error type returned by the other
+// generated functions when they find a failure, and the functions to
+// build it from the operations performed.
+//
+// For an interface named `Store`, the generated error type is
+// `StoreFuzzFailure`.
+func CodegenFailure(fuzzer Fuzzer) (string, error) {
+	return runTemplate("failure", failureTemplate, fuzzer)
+}
+
+// CodegenWithReference generates a function which will compare two
+// arbitrary implementations of the supplied interface, by performing
+// a sequence of random operations.
+//
+// For an interface named `Store`, the generated function signature
+// looks like this:
+//
+//   FuzzStoreWith(reference Store, test Store, rand *rand.Rand, maxops uint) error
+//
+// In any found discrepancies, the return value from the reference
+// `Store` (the first parameter) will be displayed as the "expected"
+// output, and the other as the "actual".
+func CodegenWithReference(fuzzer Fuzzer) (string, error) {
+	return runTemplate("withReference", withReferenceTemplate, fuzzer)
+}
+
+// CodegenInvariants generates a function which will check the
+// invariants of an implementation of the supplied interface, by
+// performing a sequence of random operations. No reference
+// implementation is needed.
+//
+// For an interface named `Store`, the generated function signature
+// looks like this:
+//
+//	FuzzStoreInvariants(test Store, rand *rand.Rand, maxops uint) error
+//
+// The invariants are checked after every operation.
+func CodegenInvariants(fuzzer Fuzzer) (string, error) {
+	return runTemplate("invariants", invariantsTemplate, fuzzer)
+}
+
+/// FUNCTION CALLS
+
+// Generate the random argument values for a call to a function. If
+// pools is true, values may be drawn from the pools of returned
+// values, named "pools".
+//
+// Arguments are stored in variables arg0 ... argN.
+func makeArgumentGenerators(fuzzer Fuzzer, function Function, pools bool) (string, error) {
+	funcs := template.FuncMap{
+		"function": func(s string) Function { return function },
+		"pools":    func(s string) bool { return pools },
+	}
+
+	return runTemplateWith("arguments", argumentsTemplate, fuzzer, funcs)
+}
+
+// Generate a call to two functions with the same signature, using the
+// argument values generated by makeArgumentGenerators.
+//
+// Return values are stored in variables reta0 ... retaN and retb0
+// ... retbN.
+//
+// If the second function is empty, only the first is called and its
+// results are discarded.
+func makeFunctionCalls(fuzzer Fuzzer, function Function, funcA, funcB string) (string, error) {
+	sides := []string{"Expected", "Actual"}
+	if funcB == "" {
+		sides = []string{""}
+	}
+
+	funcs := template.FuncMap{
+		"function":     func(s string) Function { return function },
+		"expectedFunc": func(s string) string { return funcA },
+		"actualFunc":   func(s string) string { return funcB },
+		"sides":        func(s string) []string { return sides },
+	}
+
+	return runTemplateWith("functionCall", functionCallTemplate, fuzzer, funcs)
+}
+
+// Get the name to call the reference function by. If the fuzzer is
+// for an instantiation of a generic interface and the reference
+// function is generic, it is instantiated with the same type
+// arguments.
+func referenceFunc(fuzzer Fuzzer) string {
+	name := fuzzer.Wanted.Reference.Name
+	if len(fuzzer.TypeArgs) == 0 || !fuzzer.Env.IsGenericFunc(name) {
+		return name
+	}
+
+	var args []string
+	for _, arg := range fuzzer.TypeArgs {
+		args = append(args, arg.ToString())
+	}
+
+	return name + "[" + strings.Join(args, ", ") + "]"
+}
+
+// Render the arguments to a function call, spreading the final
+// argument if the function is variadic. Callbacks and copied arguments
+// are different for each implementation, so the side ("Expected" or
+// "Actual") selects which to use.
+func callArguments(fuzzer Fuzzer, function Function, side string) string {
+	args := funcArgNames(function)
+	for i := range args {
+		if isDefaultCallback(fuzzer, function, i) || (side != "" && isCopiedArgument(fuzzer, function, i)) {
+			args[i] = args[i] + side
+		}
+	}
+
+	argstr := strings.Join(args, ", ")
+	if function.Variadic && len(function.Parameters) > 0 {
+		argstr = argstr + "..."
+	}
+	return argstr
+}
+
+// Check if an argument to a function may share memory, and so is
+// copied for each implementation.
+func isCopiedArgument(fuzzer Fuzzer, function Function, i int) bool {
+	if isDefaultCallback(fuzzer, function, i) {
+		return false
+	}
+	return fuzzer.Env.SharesMemory(function.Parameters[i])
+}
+
+// Produce some code to copy the arguments to a function which may
+// share memory, once for each implementation, so that neither can
+// change the arguments seen by the other, or by a later replay.
+func copyArguments(fuzzer Fuzzer, function Function) string {
+	var code []string
+	for i, name := range funcArgNames(function) {
+		if isCopiedArgument(fuzzer, function, i) {
+			code = append(code, fmt.Sprintf("%sExpected, %sActual := fuzzCopy(%s), fuzzCopy(%s)", name, name, name, name))
+		}
+	}
+	return strings.Join(code, "\n")
+}
+
+// Produce an expression for a description of a call to a function,
+// with the values of its arguments, for use in a failure message.
+func callDescription(function Function) string {
+	var args []string
+	var values []string
+	names := funcArgNames(function)
+	for i, name := range funcArgDisplayNames(function) {
+		if _, ok := function.Parameters[i].(*FuncType); ok {
+			args = append(args, name+"=func")
+			continue
+		}
+		args = append(args, name+"=%v")
+		values = append(values, names[i])
+	}
+
+	format := strconv.Quote(function.Name + "(" + strings.Join(args, ", ") + ")")
+	if len(values) == 0 {
+		return format
+	}
+	return "fmt.Sprintf(" + format + ", " + strings.Join(values, ", ") + ")"
+}
+
+// Produce a format string for the code of a regression test which
+// makes a call to a function on both implementations, named
+// "reference" and "test", and checks the results. The arguments are
+// filled in by the format string. This is "" if the call can't be
+// replayed from its recorded arguments, because it has callbacks.
+func regressionCall(fuzzer Fuzzer, function Function) string {
+	if len(defaultCallbacks(fuzzer, function)) > 0 {
+		return ""
+	}
+
+	escape := func(s string) string {
+		return strings.Replace(s, "%", "%%", -1)
+	}
+
+	var args []string
+	for i := range function.Parameters {
+		args = append(args, fmt.Sprintf("%%[%d]s", i+1))
+	}
+	argstr := strings.Join(args, ", ")
+	if function.Variadic && len(function.Parameters) > 0 {
+		argstr = argstr + "..."
+	}
+
+	call := func(side string, results []string) string {
+		code := side + "." + function.Name + "("
+		if len(results) > 0 {
+			code = strings.Join(results, ", ") + " := " + code
+		}
+		return escape(code) + argstr + ")"
+	}
+
+	expecteds := funcExpectedNames(function)
+	actuals := funcActualNames(function)
+	lines := []string{call("reference", expecteds), call("test", actuals)}
+	for j, ty := range function.Returns {
+		comparison := fmt.Sprintf(makeValueComparison(fuzzer, ty), expecteds[j], actuals[j])
+		message := strconv.Quote("inconsistent result in " + function.Name + "\nexpected: %v\nactual:   %v")
+		lines = append(lines,
+			escape("if !"+comparison+" {"),
+			escape("\tt.Fatalf("+message+", "+expecteds[j]+", "+actuals[j]+")"),
+			"}")
+	}
+
+	return "{\n" + indentLines(strings.Join(lines, "\n"), "\t") + "\n}"
+}
+
+// Produce the code of a regression test which checks the invariants
+// of the implementations, named "reference" and "test". The message
+// is passed as an argument, so that a "%" in the expression isn't
+// taken for a formatting directive.
+func regressionInvariants(fuzzer Fuzzer) string {
+	var lines []string
+	check := func(expr, side, message string) {
+		expr = strings.Replace(expr, "%var", side, -1)
+		lines = append(lines,
+			"if !("+expr+") {",
+			"\tt.Fatalf(\"%s\", "+strconv.Quote(message+expr)+")",
+			"}")
+	}
+
+	for _, invariant := range fuzzer.Wanted.Invariants {
+		if invariant.Both {
+			check(invariant.Expression, "reference", "invariant violated by reference implementation: ")
+			check(invariant.Expression, "test", "invariant violated by test implementation: ")
+		} else {
+			check(invariant.Expression, "test", "invariant violated: ")
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Find the imports needed by some code in a regression test: those of
+// the packages imported by the fuzzer's package which it refers to,
+// and "reflect" for the fallback comparison.
+func regressionImports(fuzzer Fuzzer, code string) []string {
+	var specs []string
+	seen := make(map[string]bool)
+
+	var scan scanner.Scanner
+	fset := token.NewFileSet()
+	src := []byte(code)
+	scan.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+
+	// Qualifiers are identifiers followed by a ".", but not
+	// preceded by one.
+	var before, prev token.Token
+	var name string
+	for {
+		_, tok, lit := scan.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		if tok == token.PERIOD && prev == token.IDENT && before != token.PERIOD && !seen[name] {
+			seen[name] = true
+			if spec, ok := fuzzer.Env.ImportSpec(name); ok {
+				specs = append(specs, spec)
+			} else if name == "reflect" {
+				specs = append(specs, strconv.Quote("reflect"))
+			}
+		}
+		before, prev, name = prev, tok, lit
+	}
+
+	sort.Strings(specs)
+	return specs
+}
+
+// Find the identifiers used in some code.
+func identifiers(code string) map[string]bool {
+	idents := make(map[string]bool)
+
+	var scan scanner.Scanner
+	fset := token.NewFileSet()
+	src := []byte(code)
+	scan.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+
+	for {
+		_, tok, lit := scan.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.IDENT {
+			idents[lit] = true
+		}
+	}
+
+	return idents
+}
+
+// Render the arguments of a function call as the arguments of a
+// recorded operation. A callback is recorded as the results it
+// returns, or nil if it has none.
+func operationArguments(fuzzer Fuzzer, function Function) string {
+	args := funcArgNames(function)
+	for i := range args {
+		if isDefaultCallback(fuzzer, function, i) {
+			if len(function.Parameters[i].(*FuncType).Returns) == 0 {
+				args[i] = "nil"
+			} else {
+				args[i] = args[i] + "Results"
+			}
+		}
+	}
+
+	return strings.Join(args, ", ")
+}
+
+// Get the type of an argument as it is stored in a recorded
+// operation.
+func operationArgumentType(fuzzer Fuzzer, function Function, i int) string {
+	if isDefaultCallback(fuzzer, function, i) {
+		return "[][]interface{}"
+	}
+	return function.Parameters[i].ToString()
+}
+
+// Produce some code to extract the arguments of a function call from
+// a recorded operation, named "op". Arguments of function type are
+// skipped unless asked for.
+func unpackArguments(fuzzer Fuzzer, function Function, callbacks bool) string {
+	var code []string
+	for i, name := range funcArgNames(function) {
+		if _, ok := function.Parameters[i].(*FuncType); ok && !callbacks {
+			continue
+		}
+		if isDefaultCallback(fuzzer, function, i) {
+			if len(function.Parameters[i].(*FuncType).Returns) == 0 {
+				continue
+			}
+			name = name + "Results"
+		}
+		code = append(code, fmt.Sprintf("%s, _ := op.args[%d].(%s)", name, i, operationArgumentType(fuzzer, function, i)))
+	}
+
+	return strings.Join(code, "\n")
+}
+
+// Check if an argument to a function is a callback which uses the
+// default generator.
+func isDefaultCallback(fuzzer Fuzzer, function Function, i int) bool {
+	if i < 0 || i >= len(function.Parameters) {
+		return false
+	}
+
+	ty := function.Parameters[i]
+	if _, ok := ty.(*FuncType); !ok {
+		return false
+	}
+
+	if _, ok := lookupParamGenerator(fuzzer, function, i); ok {
+		return false
+	}
+	_, ok := lookupGenerator(fuzzer, ty)
+	return !ok
+}
+
+// Find the provided generator for a parameter of a method, if there
+// is one.
+func lookupParamGenerator(fuzzer Fuzzer, function Function, i int) (Generator, bool) {
+	if i < 0 || i >= len(function.ParameterNames) || function.ParameterNames[i] == "" {
+		return Generator{}, false
+	}
+
+	key := function.Name + "." + function.ParameterNames[i]
+	generator, ok := fuzzer.Wanted.ParamGenerator[key]
+	generator.target = key
+	return generator, ok
+}
+
+// Get the names of the arguments to a function which are callbacks
+// using the default generator.
+func defaultCallbacks(fuzzer Fuzzer, function Function) []string {
+	var callbacks []string
+	for i, name := range funcArgNames(function) {
+		if isDefaultCallback(fuzzer, function, i) {
+			callbacks = append(callbacks, name)
+		}
+	}
+	return callbacks
+}
+
+/// VALUE INITIALISATION
+
+// Produce some code to populate the variable for an argument to a
+// function. A generator for the parameter is used in preference to
+// anything else, and for a variadic argument produces the whole slice.
+// Otherwise a variadic argument is populated with a slice of random
+// length, using the generator for the element type. For a callback
+// only the results are generated here, the callbacks themselves are
+// defined by makeFunctionCallbacks. If pools is true, values may be
+// drawn from the pools of returned values.
+func makeArgumentGenerator(fuzzer Fuzzer, function Function, i int, pools bool) (string, error) {
+	varname, err := inSlice(funcArgNames(function), i, "argument")
+	if err != nil {
+		return "", err
+	}
+
+	if generator, ok := lookupParamGenerator(fuzzer, function, i); ok {
+		return callGenerator(fuzzer, varname, generator)
+	}
+
+	ty := function.Parameters[i]
+	if isDefaultCallback(fuzzer, function, i) {
+		return makeCallbackResults(fuzzer, varname, ty.(*FuncType))
+	}
+	if !function.Variadic || i != len(function.Parameters)-1 {
+		return makeReusingGenerator(fuzzer, varname, ty, pools)
+	}
+
+	slicety, ok := ty.(*ArrayType)
+	if !ok {
+		return "", fmt.Errorf("variadic argument of non-slice type %s", ty.ToString())
+	}
+
+	length := defaultVariadicLength
+	if fuzzer.Wanted.VariadicLength != nil {
+		length = *fuzzer.Wanted.VariadicLength
+	}
+	// The length grows with the size of generated values, like
+	// the lengths of slices.
+	lenexpr := lengthExpr(length)
+	if length.Max > length.Min {
+		lenexpr = fmt.Sprintf("fuzzLengthRange(rand, %s, %d, %d)", generatorSize(fuzzer), length.Min, length.Max)
+	}
+
+	elemgen, err := makeReusingGenerator(fuzzer, varname+"[j]", slicety.ElementType, pools)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s = make(%s, %s)\nfor j := range %s {\n%s\n}", varname, ty.ToString(), lenexpr, varname, indentLines(elemgen, "\t")), nil
+}
+
+// Produce some code to generate, in advance, the values returned by a
+// callback. The results for an argument named "argFunc" are stored in
+// "argFuncResults", so that every implementation, and every replay of
+// the operation, sees the same results.
+func makeCallbackResults(fuzzer Fuzzer, varname string, ty *FuncType) (string, error) {
+	if len(ty.Returns) == 0 {
+		return "", nil
+	}
+
+	results := varname + "Results"
+	var resultNames []string
+	var resultDecls []string
+	var resultGens []string
+	for k, retty := range ty.Returns {
+		name := fmt.Sprintf("r%d", k)
+		gen, err := makeTypeGenerator(fuzzer, name, retty)
+		if err != nil {
+			return "", err
+		}
+		resultNames = append(resultNames, name)
+		resultDecls = append(resultDecls, "\t"+name+" "+retty.ToString())
+		resultGens = append(resultGens, gen)
+	}
+
+	code := []string{
+		fmt.Sprintf("var %s [][]interface{}", results),
+		fmt.Sprintf("for j, n := 0, %s; j < n; j++ {", lengthExpr(defaultCallbackLength)),
+		indentLines("var (\n"+strings.Join(resultDecls, "\n")+"\n)\n"+strings.Join(resultGens, "\n"), "\t"),
+		fmt.Sprintf("\t%s = append(%s, []interface{}{%s})", results, results, strings.Join(resultNames, ", ")),
+		"}",
+	}
+
+	return strings.Join(code, "\n"), nil
+}
+
+// Produce some code to define a callback for each implementation,
+// which records its arguments and returns the results generated by
+// makeCallbackResults; once these run out the zero values are
+// returned. For an argument named "argFunc" and the sides "Expected"
+// and "Actual", the callbacks are named "argFuncExpected" and
+// "argFuncActual", and their invocations are recorded in
+// "argFuncExpectedCalls" and "argFuncActualCalls".
+func makeCallbacks(varname string, ty *FuncType, sides []string) string {
+	var code []string
+	results := varname + "Results"
+
+	var resultNames []string
+	var resultDecls []string
+	for k, retty := range ty.Returns {
+		name := fmt.Sprintf("r%d", k)
+		resultNames = append(resultNames, name)
+		resultDecls = append(resultDecls, name+" "+retty.ToString())
+	}
+
+	var params []string
+	var paramNames []string
+	for k, paramty := range ty.Parameters {
+		name := fmt.Sprintf("a%d", k)
+		tystr := paramty.ToString()
+		if slicety, ok := paramty.(*ArrayType); ok && ty.Variadic && k == len(ty.Parameters)-1 {
+			tystr = "..." + slicety.ElementType.ToString()
+		}
+		params = append(params, name+" "+tystr)
+		paramNames = append(paramNames, name)
+	}
+	signature := fmt.Sprintf("func(%s)", strings.Join(params, ", "))
+	if len(ty.Returns) > 0 {
+		signature = signature + " (" + strings.Join(resultDecls, ", ") + ")"
+	}
+
+	for _, side := range sides {
+		callback := varname + side
+		calls := callback + "Calls"
+		code = append(code,
+			fmt.Sprintf("var %s [][]interface{}", calls),
+			fmt.Sprintf("%s := %s {", callback, signature),
+			fmt.Sprintf("\t%s = append(%s, []interface{}{%s})", calls, calls, strings.Join(paramNames, ", ")),
+		)
+		if len(ty.Returns) > 0 {
+			code = append(code, fmt.Sprintf("\tif k := len(%s) - 1; k < len(%s) {", calls, results))
+			for k, retty := range ty.Returns {
+				code = append(code, fmt.Sprintf("\t\t%s, _ = %s[k][%d].(%s)", resultNames[k], results, k, retty.ToString()))
+			}
+			code = append(code, "\t}", "\treturn "+strings.Join(resultNames, ", "))
+		}
+		code = append(code, "}")
+	}
+
+	return strings.Join(code, "\n")
+}
+
+// Produce some code to define the callbacks for all of the arguments
+// to a function which are callbacks using the default generator.
+func makeFunctionCallbacks(fuzzer Fuzzer, function Function, sides []string) string {
+	var code []string
+	for i, name := range funcArgNames(function) {
+		if isDefaultCallback(fuzzer, function, i) {
+			code = append(code, makeCallbacks(name, function.Parameters[i].(*FuncType), sides))
+		}
+	}
+	return strings.Join(code, "\n")
+}
+
+// Produce an expression for a random length in a range.
+func lengthExpr(length LengthRange) string {
+	if length.Max > length.Min && length.Min == 0 {
+		return fmt.Sprintf("rand.Intn(%d)", length.Max+1)
+	}
+	if length.Max > length.Min {
+		return fmt.Sprintf("%d+rand.Intn(%d)", length.Min, length.Max-length.Min+1)
+	}
+	return strconv.FormatUint(uint64(length.Min), 10)
+}
+
+// Find the provided generator for a type, if there is one.
+func lookupGenerator(fuzzer Fuzzer, ty Type) (Generator, bool) {
+	generators := make(map[string]Type)
+	for key, generator := range fuzzer.Wanted.Generator {
+		generators[key] = generator.Type
+	}
+
+	key, ok := findTypeKey(fuzzer.Env, ty, generators)
+	if !ok {
+		return Generator{}, false
+	}
+	generator := fuzzer.Wanted.Generator[key]
+	generator.target = key
+	return generator, true
+}
+
+// Produce some code to populate a given variable with a random value
+// of the named type, assuming a PRNG called 'rand' is in scope.
+func makeTypeGenerator(fuzzer Fuzzer, varname string, ty Type) (string, error) {
+	return makeNestedGenerator(fuzzer, varname, ty, 0)
+}
+
+// Produce some code to populate a variable, which is nested inside
+// some number of other generated values.
+func makeNestedGenerator(fuzzer Fuzzer, varname string, ty Type, depth int) (string, error) {
+	// If there's a provided generator, use that.
+	generator, ok := lookupGenerator(fuzzer, ty)
+	if ok {
+		return callGenerator(fuzzer, varname, generator)
+	}
+
+	// If it's an enumeration, usually pick one of its values.
+	if gen, ok, err := makeEnumGenerator(fuzzer, varname, ty, depth); ok || err != nil {
+		return gen, err
+	}
+
+	return makeDefaultGenerator(fuzzer, varname, ty, depth)
+}
+
+// Produce some code to populate a variable with a default generator,
+// ignoring any provided generator for the type itself.
+func makeDefaultGenerator(fuzzer Fuzzer, varname string, ty Type, depth int) (string, error) {
+	tyname := ty.ToString()
+
+	// If it's a type we can handle, supply a default generator.
+	tygen, ok := defaultGenerators[tyname]
+	if ok {
+		return fmt.Sprintf("%s = %s", varname, tygen.expression(fuzzer)), nil
+	}
+
+	// If it's a named type over a type we can handle, convert the
+	// default generator.
+	if basic, ok := fuzzer.Env.Underlying(ty); ok {
+		tygen, ok = defaultGenerators[basic]
+		if ok {
+			return fmt.Sprintf("%s = %s(%s)", varname, tyname, tygen.expression(fuzzer)), nil
+		}
+	}
+
+	// If it's a string, slice, map, pointer, or array, or a named
+	// type over one, build it from the generators for its parts.
+	under := ty
+	if named, ok := fuzzer.Env.UnderlyingType(ty); ok {
+		under = named
+	}
+	if gen, ok, err := makeCompositeGenerator(fuzzer, varname, ty, under, depth); ok || err != nil {
+		return gen, err
+	}
+
+	// Otherwise cry because generic programming in Go is hard :(
+	return "", fmt.Errorf("I don't know how to generate a %s", tyname)
+}
+
+// Find the values of an enumeration type, and the probability of
+// generating some other value, if it is one. A type is an enumeration
+// if there is an "@enum" line for it, or if it is declared in the
+// package along with some constants.
+func lookupEnum(fuzzer Fuzzer, ty Type) ([]string, float64, bool, error) {
+	enums := make(map[string]Type)
+	for key, enum := range fuzzer.Wanted.Enum {
+		enums[key] = enum.Type
+	}
+
+	if key, ok := findTypeKey(fuzzer.Env, ty, enums); ok {
+		enum := fuzzer.Wanted.Enum[key]
+		values := enum.Values
+		if len(values) == 0 {
+			values = fuzzer.Env.Constants(ty)
+		}
+		if len(values) == 0 {
+			return nil, 0, true, fmt.Errorf("no values for enumeration %s", ty.ToString())
+		}
+		return values, enum.OutOfRange, true, nil
+	}
+
+	if fuzzer.Env.IsLocal(ty) {
+		if values := fuzzer.Env.Constants(ty); len(values) > 0 {
+			return values, defaultOutOfRange, true, nil
+		}
+	}
+
+	return nil, 0, false, nil
+}
+
+// Produce some code to populate a variable of an enumeration type,
+// which picks one of its values, or sometimes generates a value as
+// usual. If the type is comparable, a value generated as usual is
+// generated again, a few times at most, until it is not one of the
+// values. Returns false if the type is not an enumeration.
+func makeEnumGenerator(fuzzer Fuzzer, varname string, ty Type, depth int) (string, bool, error) {
+	values, outOfRange, ok, err := lookupEnum(fuzzer, ty)
+	if !ok || err != nil {
+		return "", ok, err
+	}
+
+	set := fmt.Sprintf("[](%s){%s}", ty.ToString(), strings.Join(values, ", "))
+	pick := fmt.Sprintf("%s = %s[rand.Intn(%d)]", varname, set, len(values))
+	if outOfRange == 0 {
+		return pick, true, nil
+	}
+
+	// If there's no way to generate other values, always pick.
+	other, err := makeDefaultGenerator(fuzzer, varname, ty, depth)
+	if err != nil {
+		return pick, true, nil
+	}
+	if fuzzer.Env.Comparable(ty) {
+		other = fmt.Sprintf("for attempt := 0; attempt < 8; attempt++ {\n%s\n\tif !fuzzContains(%s, %s) {\n\t\tbreak\n\t}\n}", indentLines(other, "\t"), set, varname)
+	}
+	if outOfRange == 1 {
+		return other, true, nil
+	}
+
+	return fmt.Sprintf("if rand.Float64() < %v {\n%s\n} else {\n\t%s\n}", outOfRange, indentLines(other, "\t"), pick), true, nil
+}
+
+// Produce an expression for a value of a builtin type, which is
+// sometimes one of the boundary values.
+func (gen defaultGenerator) expression(fuzzer Fuzzer) string {
+	bias := edgeCases(fuzzer)
+	if bias == 0 || len(gen.Edges) == 0 {
+		return gen.Uniform
+	}
+	return fmt.Sprintf("fuzzEdge(rand, %v, %s, %s)", bias, gen.Uniform, strings.Join(gen.Edges, ", "))
+}
+
+// Get the probability of generating boundary values for a fuzzer.
+func edgeCases(fuzzer Fuzzer) float64 {
+	if fuzzer.Wanted.EdgeCases != nil {
+		return *fuzzer.Wanted.EdgeCases
+	}
+	return defaultEdgeCases
+}
+
+// Produce some code to call a provided generator function, or to
+// evaluate a generator expression, to populate a variable.
+func callGenerator(fuzzer Fuzzer, varname string, generator Generator) (string, error) {
+	if generator.Expression != "" {
+		expr := strings.NewReplacer("%rand", "rand", "%size", generatorSize(fuzzer)).Replace(generator.Expression)
+		return fmt.Sprintf("%s = %s", varname, expr), nil
+	}
+	if generator.Regexp != "" {
+		return fmt.Sprintf("%s = %s(fuzzRegexp(rand, %s, %s))", varname, generator.Type.ToString(), generatorSize(fuzzer), generatorPatternVar(generator)), nil
+	}
+	if generator.Grammar != nil {
+		start := generator.Grammar.Rules[0].Name
+		return fmt.Sprintf("%s = %s(fuzzGrammar(rand, %s, %s, %q))", varname, generator.Type.ToString(), generatorSize(fuzzer), generatorPatternVar(generator), start), nil
+	}
+
+	args := "rand"
+	if generator.IsSized {
+		args = args + ", " + generatorSize(fuzzer)
+	}
+
+	if generator.IsStateful {
+		state := "state"
+		if generator.State != "" {
+			state = generatorStateVar(generator)
+		} else if fuzzer.Wanted.GeneratorState == "" {
+			return "", errors.New("stateful generator used when no initial state given")
+		}
+		return fmt.Sprintf("%s, %s = %s(%s, %s)", varname, state, generator.Name, args, state), nil
+	}
+	return fmt.Sprintf("%s = %s(%s)", varname, generator.Name, args), nil
+}
+
+// Get the name of the state variable of a generator with its own
+// state. This is named after what the generator is for as well as
+// its function, so generators using the same function have
+// independent states.
+func generatorStateVar(generator Generator) string {
+	ident := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	return "state" + capitalise(filter(generator.target, ident)) + capitalise(filter(generator.Name, ident))
+}
+
+// Get the name of the variable holding the parsed regular expression
+// or grammar of a generator.
+func generatorPatternVar(generator Generator) string {
+	return typeNameToVarName("pattern", generator.Type)
+}
+
+// Produce some code to parse the regular expression or grammar of a
+// generator.
+func generatorPattern(generator Generator) string {
+	if generator.Regexp != "" {
+		return fmt.Sprintf("fuzzParseRegexp(%q)", generator.Regexp)
+	}
+
+	// Grammars are checked when the fuzzer is reconciled with its
+	// interface, so this cannot fail.
+	shortest, _ := grammarShortest(*generator.Grammar)
+
+	code := []string{"map[string]fuzzRule{"}
+	for i, rule := range generator.Grammar.Rules {
+		var alternatives []string
+		for _, alternative := range rule.Alternatives {
+			var symbols []string
+			for _, symbol := range alternative {
+				switch {
+				case symbol.Rule != "":
+					symbols = append(symbols, fmt.Sprintf("{Rule: %q}", symbol.Rule))
+				case symbol.Regexp != "":
+					symbols = append(symbols, fmt.Sprintf("{Regexp: fuzzParseRegexp(%q)}", symbol.Regexp))
+				default:
+					symbols = append(symbols, fmt.Sprintf("{Text: %q}", symbol.Text))
+				}
+			}
+			alternatives = append(alternatives, "{"+strings.Join(symbols, ", ")+"}")
+		}
+		code = append(code, fmt.Sprintf("\t%q: {Alternatives: [][]fuzzSymbol{%s}, Shortest: %d},", rule.Name, strings.Join(alternatives, ", "), shortest[i]))
+	}
+	code = append(code, "}")
+	return strings.Join(code, "\n")
+}
+
+// Find the alternative of each rule of a grammar which soonest leaves
+// no rules to replace, so that the strings derived from the grammar
+// can be kept small. It is an error if a rule is not defined, or if
+// it can never be replaced by text alone.
+func grammarShortest(grammar Grammar) ([]int, error) {
+	indices := make(map[string]int)
+	for i, rule := range grammar.Rules {
+		indices[rule.Name] = i
+	}
+	for _, rule := range grammar.Rules {
+		for _, alternative := range rule.Alternatives {
+			for _, symbol := range alternative {
+				if _, ok := indices[symbol.Rule]; symbol.Rule != "" && !ok {
+					return nil, fmt.Errorf("grammar rule '%s' refers to undefined rule '%s'", rule.Name, symbol.Rule)
+				}
+			}
+		}
+	}
+
+	// The depth of the shortest derivation from each rule, or zero
+	// if none has been found yet. This only decreases, so repeating
+	// until nothing changes terminates.
+	depths := make([]int, len(grammar.Rules))
+	shortest := make([]int, len(grammar.Rules))
+	for changed := true; changed; {
+		changed = false
+		for i, rule := range grammar.Rules {
+			for j, alternative := range rule.Alternatives {
+				depth := 1
+				for _, symbol := range alternative {
+					if symbol.Rule == "" {
+						continue
+					}
+					sub := depths[indices[symbol.Rule]]
+					if sub == 0 {
+						depth = 0
+						break
+					}
+					if sub+1 > depth {
+						depth = sub + 1
+					}
+				}
+				if depth > 0 && (depths[i] == 0 || depth < depths[i]) {
+					depths[i] = depth
+					shortest[i] = j
+					changed = true
+				}
+			}
+		}
+	}
+
+	for i, rule := range grammar.Rules {
+		if depths[i] == 0 {
+			return nil, fmt.Errorf("grammar rule '%s' never finishes", rule.Name)
+		}
+	}
+	return shortest, nil
+}
+
+// Check if any of the generators of a fuzzer are given by a regular
+// expression or grammar.
+func usesPatterns(fuzzer Fuzzer) bool {
+	for _, generator := range fuzzer.Wanted.Generator {
+		if generator.Regexp != "" || generator.Grammar != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Produce some code to declare the variables used by the code to
+// generate the arguments of any method.
+func generatorStates(fuzzer Fuzzer) string {
+	var code string
+	sized := sizedFuzzer(fuzzer)
+	for _, function := range fuzzer.Methods {
+		gens, _ := makeArgumentGenerators(sized, function, true)
+		code = code + gens + "\n"
+	}
+	return declareGeneratorStates(fuzzer, code)
+}
+
+// Produce some code to declare the variables used by the code to
+// generate the arguments of the reference implementation's
+// constructor, which is run before any method.
+func referenceGeneratorStates(fuzzer Fuzzer) string {
+	gens, _ := makeArgumentGenerators(fuzzer, fuzzer.Wanted.Reference, false)
+	return declareGeneratorStates(fuzzer, gens)
+}
+
+// Produce some code to declare the variables which some generated
+// code refers to: the state variables of stateful generators, which
+// are the shared "@generator state" and those of generators with
+// their own states, and the parsed patterns of generators given by
+// regular expressions and grammars.
+func declareGeneratorStates(fuzzer Fuzzer, code string) string {
+	states := make(map[string]string)
+	if fuzzer.Wanted.GeneratorState != "" {
+		states["state"] = fuzzer.Wanted.GeneratorState
+	}
+	for key, generator := range fuzzer.Wanted.Generator {
+		generator.target = key
+		if generator.IsStateful && generator.State != "" {
+			states[generatorStateVar(generator)] = generator.State
+		}
+		if generator.Regexp != "" || generator.Grammar != nil {
+			states[generatorPatternVar(generator)] = generatorPattern(generator)
+		}
+	}
+	for _, fieldgen := range fuzzer.Wanted.FieldGenerator {
+		if fieldgen.IsStateful && fieldgen.State != "" {
+			states[generatorStateVar(fieldgen.Generator())] = fieldgen.State
+		}
+	}
+	for key, generator := range fuzzer.Wanted.ParamGenerator {
+		generator.target = key
+		if generator.IsStateful && generator.State != "" {
+			states[generatorStateVar(generator)] = generator.State
+		}
+	}
+	if len(states) == 0 {
+		return ""
+	}
+
+	// Unused variables are an error, so only declare those which
+	// the generated code refers to.
+	used := identifiers(code)
+
+	var names []string
+	for name := range states {
+		if used[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var decls []string
+	for _, name := range names {
+		decls = append(decls, fmt.Sprintf("%s := %s", name, states[name]))
+	}
+	return strings.Join(decls, "\n")
+}
+
+// Get the maximum size of generated values for a fuzzer.
+func maxSize(fuzzer Fuzzer) uint {
+	if fuzzer.Wanted.Size != 0 {
+		return fuzzer.Wanted.Size
+	}
+	return defaultSize
+}
 
-{{end}}	for i := uint(0); i < maxops; i++ {
-		// Pick a random number between 0 and the number of methods of the interface. Then do that method on
-		// both, check for discrepancy, and bail out on error. Simple!
+// Get the expression for the size of generated values, which is the
+// maximum size unless the code is generated inside the loop of
+// operations.
+func generatorSize(fuzzer Fuzzer) string {
+	if fuzzer.size != "" {
+		return fuzzer.size
+	}
+	return strconv.FormatUint(uint64(maxSize(fuzzer)), 10)
+}
 
-		actionToPerform := rand.Intn({{$count}})
+// Get a copy of a fuzzer which generates values of the size in the
+// "size" variable of the loop of operations.
+func sizedFuzzer(fuzzer Fuzzer) Fuzzer {
+	fuzzer.size = "size"
+	return fuzzer
+}
 
-		switch actionToPerform { {{range $i, $function := .Methods}}
-		case {{$i}}:
-			// Call the method on both implementations
-{{indent (makeFunCalls $fuzzer $function (printf "reference.%s" $function.Name) (printf "test.%s" $function.Name)) "\t\t\t"}}
+// Check if any method is given callbacks using the default generator,
+// whose invocations must be compared.
+func usesCallbacks(fuzzer Fuzzer) bool {
+	for _, function := range fuzzer.Methods {
+		if len(defaultCallbacks(fuzzer, function)) > 0 {
+			return true
+		}
+	}
+	return false
+}
 
-			// And check for discrepancies.{{range $j, $ty := $function.Returns}}{{$expected := expected $function $j}}{{$actual   := actual $function $j}}
-			if !{{printf (comparison $fuzzer $ty) $expected $actual}} {
-				return fmt.Errorf("inconsistent result in {{$function.Name}}\nexpected: %v\nactual:   %v", {{$expected}}, {{$actual}})
-			}{{end}}{{end}}
-		} {{range $i, $invariant := .Wanted.Invariants}}
+// Check if the code to generate the arguments of any method uses the
+// "size" variable, which must then be declared.
+func usesSize(fuzzer Fuzzer) bool {
+	sized := sizedFuzzer(fuzzer)
+	for _, function := range fuzzer.Methods {
+		code, err := makeArgumentGenerators(fuzzer, function, true)
+		if err != nil {
+			continue
+		}
+		sizedCode, err := makeArgumentGenerators(sized, function, true)
+		if err != nil || sizedCode != code {
+			return true
+		}
+	}
+	return false
+}
 
-		if !({{sed $invariant "%var" "reference"}}) {
-			return errors.New("invariant violated: {{$invariant}}")
+// Find the provided generator for a field of a struct type, if there
+// is one.
+func lookupFieldGenerator(fuzzer Fuzzer, ty Type, field string) (FieldGenerator, bool) {
+	structs := make(map[string]Type)
+	for key, fieldgen := range fuzzer.Wanted.FieldGenerator {
+		if fieldgen.Field == field {
+			structs[key] = fieldgen.Type
 		}
-{{end}}
 	}
 
-	return nil
-}`
+	key, ok := findTypeKey(fuzzer.Env, ty, structs)
+	if !ok {
+		return FieldGenerator{}, false
+	}
+	return fuzzer.Wanted.FieldGenerator[key], true
+}
 
-	// Template used by MakeFunctionCalls.
-	functionCallTemplate = `
-{{$fuzzer       := . }}
-{{$function     := function ""}}
-{{$expecteds    := expecteds $function}}
-{{$actuals      := actuals $function}}
-{{$arguments    := arguments $function}}
-{{$expectedFunc := expectedFunc ""}}
-{{$actualFunc   := actualFunc ""}}
+// Produce some code to populate a variable of a string, slice, map,
+// pointer, array, or struct type, given its name and underlying
+// type. The lengths are bounded by the size of the fuzzer, and only
+// the exported fields of structs are populated. Returns false if the
+// type is not one of these.
+func makeCompositeGenerator(fuzzer Fuzzer, varname string, ty, under Type, depth int) (string, bool, error) {
+	tyname := ty.ToString()
+	size := generatorSize(fuzzer)
+	bias := edgeCases(fuzzer)
+
+	// Loop variables are numbered by depth, so that nested loops
+	// don't shadow each other.
+	suffix := strconv.Itoa(depth)
+	nested := func(varname string, ty Type) (string, error) {
+		return makeNestedGenerator(fuzzer, varname, ty, depth+1)
+	}
 
-{{if len $arguments | ne 0}}
-var ({{range $i, $ty := $function.Parameters}}
-	{{argument $function $i}} {{toString $ty}}{{end}}
-)
-{{range $i, $ty := $function.Parameters}}
-{{makeTyGen $fuzzer (argument $function $i) $ty}}{{end}}{{end}}
+	convert := func(expr, basetype string) string {
+		if tyname == basetype {
+			return fmt.Sprintf("%s = %s", varname, expr)
+		}
+		return fmt.Sprintf("%s = %s(%s)", varname, tyname, expr)
+	}
 
-{{if len $expecteds | eq 0}}
-{{$expectedFunc}}({{varV $arguments}})
-{{$actualFunc}}({{varV $arguments}})
-{{else}}
-{{varV $expecteds}} := {{$expectedFunc}}({{varV $arguments}})
-{{varV $actuals}} := {{$actualFunc}}({{varV $arguments}})
-{{end}}`
-)
+	switch x := under.(type) {
+	case *BasicType:
+		if string(*x) == "string" {
+			return convert(fmt.Sprintf("fuzzString(rand, %s, %v)", size, bias), "string"), true, nil
+		}
+	case *ArrayType:
+		if elem := x.ElementType.ToString(); x.Length == "" && (elem == "byte" || elem == "uint8") {
+			return convert(fmt.Sprintf("fuzzBytes(rand, %s, %v)", size, bias), "[]("+elem+")"), true, nil
+		}
 
-/// ENTRY POINT
+		if x.Length == "" && depth >= maxGeneratorDepth {
+			return "", true, nil
+		}
 
-// CodeGen generate code for the fuzzers.
-func CodeGen(options CodeGenOptions, imports []*ast.ImportSpec, fuzzers []Fuzzer) (string, []error) {
-	var code string
-	var errs []error
+		index := "i" + suffix
+		elemgen, err := nested(varname+"["+index+"]", x.ElementType)
+		if err != nil {
+			return "", true, err
+		}
 
-	if options.Complete {
-		code = generatePreamble(options.PackageName, imports)
-	}
+		var code []string
+		if x.Length == "" {
+			code = append(code, fmt.Sprintf("%s = make(%s, fuzzLength(rand, %s, %v))", varname, tyname, size, bias))
+		}
+		if elemgen != "" {
+			code = append(code, fmt.Sprintf("for %s := range %s {\n%s\n}", index, varname, indentLines(elemgen, "\t")))
+		}
+		return strings.Join(code, "\n"), true, nil
+	case *MapType:
+		if depth >= maxGeneratorDepth {
+			return "", true, nil
+		}
 
-	codeGenErr := func(fuzzer Fuzzer, err error) error {
-		return fmt.Errorf("error occurred whilst generating code for '%s': %s", fuzzer.Name, err)
-	}
+		key := "key" + suffix
+		value := "value" + suffix
+		keygen, err := nested(key, x.KeyType)
+		if err != nil {
+			return "", true, err
+		}
+		valuegen, err := nested(value, x.ValueType)
+		if err != nil {
+			return "", true, err
+		}
 
-	for _, fuzzer := range fuzzers {
-		code = code + "// " + fuzzer.Name + "\n\n"
+		count := "n" + suffix
+		body := joinCode(
+			fmt.Sprintf("var %s %s\nvar %s %s", key, x.KeyType.ToString(), value, x.ValueType.ToString()),
+			keygen,
+			valuegen,
+			fmt.Sprintf("%s[%s] = %s", varname, key, value),
+		)
+		return fmt.Sprintf("%s = make(%s)\nfor %s := fuzzLength(rand, %s, %v); %s > 0; %s-- {\n%s\n}", varname, tyname, count, size, bias, count, count, indentLines(body, "\t")), true, nil
+	case *PointerType:
+		if depth >= maxGeneratorDepth {
+			return "", true, nil
+		}
 
-		// FuzzTest...(... *testing.T)
-		if !(options.NoTestCase || options.NoDefaultFuzz) {
-			generated, err := CodegenTestCase(fuzzer)
-			if err != nil {
-				errs = append(errs, codeGenErr(fuzzer, err))
+		targetgen, err := nested("(*"+varname+")", x.TargetType)
+		if err != nil {
+			return "", true, err
+		}
+
+		// Pointers are nil one time in four.
+		body := joinCode(fmt.Sprintf("%s = new(%s)", varname, x.TargetType.ToString()), targetgen)
+		return fmt.Sprintf("if rand.Intn(4) == 0 {\n\t%s = nil\n} else {\n%s\n}", varname, indentLines(body, "\t")), true, nil
+	case *StructType:
+		var code []string
+		for _, field := range x.Fields {
+			if !ast.IsExported(field.Name) {
 				continue
 			}
-			code = code + generated + "\n\n"
-		}
 
-		// Fuzz...(... *rand.Rand, uint)
-		if !options.NoDefaultFuzz {
-			generated, err := CodegenWithDefaultReference(fuzzer)
+			fieldvar := varname + "." + field.Name
+			var fieldgen string
+			var err error
+			if generator, ok := lookupFieldGenerator(fuzzer, ty, field.Name); ok {
+				fieldgen, err = callGenerator(fuzzer, fieldvar, generator.Generator())
+			} else {
+				// Fields aren't nested containers, so they are
+				// at the same depth as the struct.
+				fieldgen, err = makeNestedGenerator(fuzzer, fieldvar, field.Type, depth)
+			}
 			if err != nil {
-				errs = append(errs, codeGenErr(fuzzer, err))
-				continue
+				return "", true, fmt.Errorf("field %s of %s: %s", field.Name, tyname, err)
 			}
-			code = code + generated + "\n\n"
+			code = append(code, fieldgen)
 		}
+		return joinCode(code...), true, nil
+	}
 
-		generated, err := CodegenWithReference(fuzzer)
-		if err != nil {
-			errs = append(errs, codeGenErr(fuzzer, err))
-			continue
+	return "", false, nil
+}
+
+// Join lines of generated code, skipping any which are empty.
+func joinCode(code ...string) string {
+	var lines []string
+	for _, line := range code {
+		if line != "" {
+			lines = append(lines, line)
 		}
-		code = code + generated + "\n\n"
 	}
+	return strings.Join(lines, "\n")
+}
 
-	code, err := fixImports(options, code)
-	if err != nil {
-		errs = append(errs, err)
+/// VALUE SHRINKING
+
+// Produce an expression for a function to shrink an argument to a
+// function, as it is stored in a recorded operation. This is empty if
+// the argument cannot be shrunk. An argument with a generator of its
+// own is only shrunk by a provided shrinker.
+func makeArgumentShrinker(fuzzer Fuzzer, function Function, i int) string {
+	if _, ok := lookupParamGenerator(fuzzer, function, i); ok {
+		shrinker, _ := lookupShrinker(fuzzer, function.Parameters[i])
+		return shrinker
+	}
+	if isDefaultCallback(fuzzer, function, i) {
+		// Callbacks can return fewer results.
+		if len(function.Parameters[i].(*FuncType).Returns) == 0 {
+			return ""
+		}
+		return "func(v [][]interface{}) [][][]interface{} { return fuzzShrinkSlice(v, nil) }"
 	}
 
-	return code, errs
+	shrinker, _ := makeTypeShrinker(fuzzer, function.Parameters[i])
+	return shrinker
 }
 
-// Generates the header for a complete source file: the package name
-// and the imports. These imports may be both overzealous (all imports
-// from the source file are copied across) and incomplete (imports the
-// generated functions pull in aren't added), so the FixImports
-// function must be called after the full code has been generated to
-// fix this up.
-func generatePreamble(packagename string, imports []*ast.ImportSpec) string {
-	preamble := "package " + packagename + "\n\n"
+// Check if any of the arguments to a function can be shrunk.
+func hasArgumentShrinkers(fuzzer Fuzzer, function Function) bool {
+	for i := range function.Parameters {
+		if makeArgumentShrinker(fuzzer, function, i) != "" {
+			return true
+		}
+	}
+	return false
+}
 
-	for _, iport := range imports {
-		preamble = preamble + generateImport(iport) + "\n"
+// Produce an expression for a function of type "func(T) []T" to
+// shrink values of the given type, if there is one. The default
+// shrinkers are only used for values made by the default generators,
+// as they may produce values which a provided generator never would.
+func makeTypeShrinker(fuzzer Fuzzer, ty Type) (string, bool) {
+	tyname := ty.ToString()
+
+	// If there's a provided shrinker, use that.
+	if shrinker, ok := lookupShrinker(fuzzer, ty); ok {
+		return shrinker, true
+	}
+	if _, ok := lookupGenerator(fuzzer, ty); ok {
+		return "", false
 	}
 
-	return preamble + "\n"
-}
+	// The default shrinkers are generic, so they work for named
+	// types over the types they handle as well.
+	if shrinker, ok := defaultShrinkers[tyname]; ok {
+		return shrinker + "[" + tyname + "]", true
+	}
+	if basic, ok := fuzzer.Env.Underlying(ty); ok {
+		if shrinker, ok := defaultShrinkers[basic]; ok {
+			return shrinker + "[" + tyname + "]", true
+		}
+	}
 
-// Generates an import statement from an *ast.ImportSpec.
-func generateImport(iport *ast.ImportSpec) string {
-	if iport.Name != nil {
-		return "import " + iport.Name.Name + " " + iport.Path.Value
+	switch x := ty.(type) {
+	case *ArrayType:
+		if x.Length != "" {
+			break
+		}
+		elem, ok := makeTypeShrinker(fuzzer, x.ElementType)
+		if !ok {
+			elem = "nil"
+		}
+		return fmt.Sprintf("func(v %s) [](%s) { return fuzzShrinkSlice(v, %s) }", tyname, tyname, elem), true
+	case *MapType:
+		value, ok := makeTypeShrinker(fuzzer, x.ValueType)
+		if !ok {
+			value = "nil"
+		}
+		return fmt.Sprintf("func(v %s) [](%s) { return fuzzShrinkMap(v, %s) }", tyname, tyname, value), true
 	}
-	return "import " + iport.Path.Value
+
+	return "", false
 }
 
-// Adds and removes imports with 'goimports'.
-func fixImports(options CodeGenOptions, code string) (string, error) {
-	if options.Complete {
-		cbytes, err := goimports.Process(options.Filename, []byte(code), nil)
-		return string(cbytes), err
+// Find the provided shrinker for a type, if there is one.
+func lookupShrinker(fuzzer Fuzzer, ty Type) (string, bool) {
+	shrinkers := make(map[string]Type)
+	for key, shrinker := range fuzzer.Wanted.Shrinker {
+		shrinkers[key] = shrinker.Type
 	}
-	return code, nil
+
+	key, ok := findTypeKey(fuzzer.Env, ty, shrinkers)
+	if !ok {
+		return "", false
+	}
+	return fuzzer.Wanted.Shrinker[key].Name, true
 }
 
-/// MAIN CODE GENERATORS
+/// VALUE REUSE
 
-// CodegenTestCase generates a function which can be used as a test
-// case when given an implementation to test.
-//
-// For an interface named `Store` with a generating function that
-// takes a single `int`, the generated function signature looks like
-// this:
-//
-//   FuzzTestStore(makeTest (func(int) Store), t *testing.T)
-//
-// This test case will call `FuzzStore` (see
-// CodegenWithDefaultReference) with a max number of 100 operations.
-func CodegenTestCase(fuzzer Fuzzer) (string, error) {
-	return runTemplate("testCase", testCaseTemplate, fuzzer)
-}
+// Get the types of which returned values are kept for reuse, in the
+// order of their pools.
+func reusedTypes(fuzzer Fuzzer) []Reuse {
+	var keys []string
+	for key := range fuzzer.Wanted.Reuse {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-// CodegenWithDefaultReference generates a function which will compare
-// a supplied implementation of the interface against the reference,
-// by performing a sequence of random operations.
-//
-// For an interface named `Store` with a generating function that
-// takes a single `int`, the generated function signature looks like
-// this:
-//
-//   FuzzStore(makeTest (func(int) Store), rand *rand.Rand, maxops uint) error
-//
-// This function will call `FuzzStoreWith` (see CodegenWithReference)
-// with the default reference.
-func CodegenWithDefaultReference(fuzzer Fuzzer) (string, error) {
-	return runTemplate("withDefaultReference", withDefaultReferenceTemplate, fuzzer)
+	var reuses []Reuse
+	for _, key := range keys {
+		reuses = append(reuses, fuzzer.Wanted.Reuse[key])
+	}
+	return reuses
 }
 
-// CodegenWithReference generates a function which will compare two
-// arbitrary implementations of the supplied interface, by performing
-// a sequence of random operations.
-//
-// For an interface named `Store`, the generated function signature
-// looks like this:
-//
-//   FuzzStoreWith(reference Store, test Store, rand *rand.Rand, maxops uint) error
-//
-// In any found discrepancies, the return value from the reference
-// `Store` (the first parameter) will be displayed as the "expected"
-// output, and the other as the "actual".
-func CodegenWithReference(fuzzer Fuzzer) (string, error) {
-	return runTemplate("withReference", withReferenceTemplate, fuzzer)
+// Find the pool of returned values of a type, if there is one.
+func lookupPool(fuzzer Fuzzer, ty Type) (int, Reuse, bool) {
+	pools := make(map[string]Type)
+	for key, reuse := range fuzzer.Wanted.Reuse {
+		pools[key] = reuse.Type
+	}
+
+	key, ok := findTypeKey(fuzzer.Env, ty, pools)
+	if !ok {
+		return 0, Reuse{}, false
+	}
+	for i, reuse := range reusedTypes(fuzzer) {
+		if reuse.Type.ToString() == key {
+			return i, reuse, true
+		}
+	}
+	return 0, Reuse{}, false
 }
 
-/// FUNCTION CALLS
+// Produce some code to generate a value of a type, drawing it from
+// the pool of returned values instead with some probability, if
+// asked to and there is one.
+func makeReusingGenerator(fuzzer Fuzzer, varname string, ty Type, pools bool) (string, error) {
+	gen, err := makeTypeGenerator(fuzzer, varname, ty)
+	if err != nil || !pools {
+		return gen, err
+	}
 
-// Generate a call to two functions with the same signature, with
-// random argument values.
-//
-// Arguments are stored in variables arg0 ... argN. Return values in
-// variables reta0 ... retaN and retb0 ... retbN.
-func makeFunctionCalls(fuzzer Fuzzer, function Function, funcA, funcB string) (string, error) {
-	funcs := template.FuncMap{
-		"function":     func(s string) Function { return function },
-		"expectedFunc": func(s string) string { return funcA },
-		"actualFunc":   func(s string) string { return funcB },
+	i, reuse, ok := lookupPool(fuzzer, ty)
+	if !ok {
+		return gen, nil
 	}
 
-	return runTemplateWith("functionCall", functionCallTemplate, fuzzer, funcs)
+	pool := fmt.Sprintf("pools.pool%d", i)
+	probability := strconv.FormatFloat(reuse.Probability, 'g', -1, 64)
+	return fmt.Sprintf("if len(%[1]s) > 0 && rand.Float64() < %[2]s {\n\t%[3]s = %[1]s[rand.Intn(len(%[1]s))]\n} else {\n%[4]s\n}", pool, probability, varname, indentLines(gen, "\t")), nil
 }
 
-/// VALUE INITIALISATION
-
-// Produce some code to populate a given variable with a random value
-// of the named type, assuming a PRNG called 'rand' is in scope.
-func makeTypeGenerator(fuzzer Fuzzer, varname string, ty Type) (string, error) {
-	tyname := ty.ToString()
-
-	// If there's a provided generator, use that.
-	generator, ok := fuzzer.Wanted.Generator[tyname]
-	if ok {
-		if generator.IsStateful {
-			if fuzzer.Wanted.GeneratorState == "" {
-				return "", errors.New("stateful generator used when no initial state given")
+// Produce some code to add the values returned by the reference
+// implementation from a function call to the pools, named "pools".
+// Slices and arrays of a reused type have all of their elements
+// added. Values which may share memory are copied, so that the
+// reference implementation can't change them later.
+func poolResults(fuzzer Fuzzer, function Function) string {
+	var code []string
+	for j, ty := range function.Returns {
+		expected := funcExpectedNames(function)[j]
+		if i, _, ok := lookupPool(fuzzer, ty); ok {
+			if fuzzer.Env.SharesMemory(ty) {
+				expected = "fuzzCopy(" + expected + ")"
 			}
-			return fmt.Sprintf("%s, state = %s(rand, state)", varname, generator.Name), nil
+			code = append(code, fmt.Sprintf("pools.pool%d = append(pools.pool%d, %s)", i, i, expected))
+			continue
 		}
-		return fmt.Sprintf("%s = %s(rand)", varname, generator.Name), nil
-	}
 
-	// If it's a type we can handle, supply a default generator.
-	var tygen string
-	tygen, ok = defaultGenerators[tyname]
-	if ok {
-		return fmt.Sprintf("%s = %s", varname, tygen), nil
+		arrty, ok := ty.(*ArrayType)
+		if !ok {
+			continue
+		}
+		if i, _, ok := lookupPool(fuzzer, arrty.ElementType); ok {
+			if arrty.Length != "" {
+				expected = expected + "[:]"
+			}
+			if fuzzer.Env.SharesMemory(arrty.ElementType) {
+				expected = "fuzzCopy(" + expected + ")"
+			}
+			code = append(code, fmt.Sprintf("pools.pool%d = append(pools.pool%d, %s...)", i, i, expected))
+		}
 	}
 
-	// Otherwise cry because generic programming in Go is hard :(
-	return "", fmt.Errorf("I don't know how to generate a %s", tyname)
+	return strings.Join(code, "\n")
 }
 
 /// VALUE COMPARISON
@@ -359,15 +3015,25 @@ func makeTypeGenerator(fuzzer Fuzzer, varname string, ty Type) (string, error) {
 // Produce a format string to compare two values of the same type.
 // given the variable names.
 func makeValueComparison(fuzzer Fuzzer, ty Type) string {
-	tyname := ty.ToString()
-	comparison, ok := defaultComparisons[tyname]
-	if !ok {
-		comparison = fallbackComparison
+	defaults := make(map[string]Type)
+	for key := range defaultComparisons {
+		keyty := BasicType(key)
+		defaults[key] = &keyty
+	}
+	comparison := fallbackComparison
+	key, ok := findTypeKey(fuzzer.Env, ty, defaults)
+	if ok {
+		comparison = defaultComparisons[key]
 	}
 
 	// If there's a provided comparison, use that.
-	tycomp, ok := fuzzer.Wanted.Comparison[tyname]
+	comparisons := make(map[string]Type)
+	for key, tycomp := range fuzzer.Wanted.Comparison {
+		comparisons[key] = tycomp.Type
+	}
+	key, ok = findTypeKey(fuzzer.Env, ty, comparisons)
 	if ok {
+		tycomp := fuzzer.Wanted.Comparison[key]
 		comparison = "%s." + tycomp.Name + "(%s)"
 
 		if tycomp.IsFunction {
@@ -378,6 +3044,34 @@ func makeValueComparison(fuzzer Fuzzer, ty Type) string {
 	return comparison
 }
 
+/// TYPE LOOKUP
+
+// Find the key in a map of ToString'd Types which refers to the same
+// type as the one given. An exact match is preferred, otherwise the
+// types are resolved in the environment and compared for identity.
+func findTypeKey(env *TypeEnv, ty Type, keys map[string]Type) (string, bool) {
+	tyname := ty.ToString()
+	if _, ok := keys[tyname]; ok {
+		return tyname, true
+	}
+
+	// Check in a fixed order, so the generated code is
+	// deterministic.
+	var names []string
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if keys[name] != nil && env.Identical(keys[name], ty) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
 /// TEMPLATES
 
 // Run a template and return the output.
@@ -438,10 +3132,47 @@ func runTemplateWith(tplName, tpl string, fuzzer Fuzzer, overrides template.Func
 		},
 		// Make a function call
 		"makeFunCalls": makeFunctionCalls,
+		// Name of the reference function
+		"referenceFunc": referenceFunc,
 		// Make a value comparison
 		"comparison": makeValueComparison,
 		// Make a type generator
 		"makeTyGen": makeTypeGenerator,
+		// Make an argument generator
+		"makeArgGen":  makeArgumentGenerator,
+		"makeArgGens": makeArgumentGenerators,
+		// Generate values which grow over the run
+		"sized":    sizedFuzzer,
+		"usesSize": usesSize,
+		// Declare the states of generators
+		"generatorStates":          generatorStates,
+		"referenceGeneratorStates": referenceGeneratorStates,
+		"maxSize":                  maxSize,
+		// Reuse returned values as arguments
+		"reuses":      reusedTypes,
+		"poolResults": poolResults,
+		// Define the callbacks for a function call
+		"makeCallbacks": makeFunctionCallbacks,
+		// Describe a function call
+		"describe": callDescription,
+		// Record and replay operations
+		"opArgs":     operationArguments,
+		"argType":    operationArgumentType,
+		"unpackArgs": unpackArguments,
+		// Shrink the arguments of operations
+		"shrinker":     makeArgumentShrinker,
+		"hasShrinkers": hasArgumentShrinkers,
+		// Render the arguments to a function call
+		"callArgs": callArguments,
+		"copyArgs": copyArguments,
+		// Replay operations in a regression test
+		"regressionCall":       regressionCall,
+		"regressionInvariants": regressionInvariants,
+		"regressionImports":    regressionImports,
+		// Callbacks using the default generator
+		"isCallback":    isDefaultCallback,
+		"callbacks":     defaultCallbacks,
+		"usesCallbacks": usesCallbacks,
 		// Replace one string with another
 		"sed": func(s, old, new string) string {
 			return strings.Replace(s, old, new, -1)
@@ -474,8 +3205,37 @@ func inSlice(ss []string, i int, name string) (string, error) {
 
 // Produce unique variable names for function arguments. These do not
 // clash with names produced by funcExpectedNames or funcActualNames.
+//
+// If the function has named parameters, the names are derived from
+// those instead of from the types: the parameter "from" becomes the
+// variable "argFrom".
 func funcArgNames(function Function) []string {
-	return typeListNames("arg", function.Parameters)
+	names := typeListNames("arg", function.Parameters)
+
+	for i, name := range function.ParameterNames {
+		if i >= len(names) || name == "" || name == "_" {
+			continue
+		}
+		names[i] = "arg" + capitalise(name)
+	}
+
+	return uniqueNames(names)
+}
+
+// Produce names for function arguments to display in failure
+// messages. These are the parameter names if there are any, and the
+// variable names otherwise.
+func funcArgDisplayNames(function Function) []string {
+	names := funcArgNames(function)
+
+	for i, name := range function.ParameterNames {
+		if i >= len(names) || name == "" || name == "_" {
+			continue
+		}
+		names[i] = name
+	}
+
+	return names
 }
 
 // Produce unique variable names for actual function returns. These do
@@ -495,19 +3255,30 @@ func funcExpectedNames(function Function) []string {
 func typeListNames(prefix string, tylist []Type) []string {
 	var names []string
 
-	for i, ty := range tylist {
+	for _, ty := range tylist {
 		// Generate a name for this variable based on the type.
-		name := typeNameToVarName(prefix, ty)
-		for _, prior := range names {
+		names = append(names, typeNameToVarName(prefix, ty))
+	}
+
+	return uniqueNames(names)
+}
+
+// Make a list of variable names unique by suffixing any repeats with
+// their index.
+func uniqueNames(names []string) []string {
+	var unique []string
+
+	for i, name := range names {
+		for _, prior := range unique {
 			if name == prior {
 				name = name + strconv.Itoa(i)
 				break
 			}
 		}
-		names = append(names, name)
+		unique = append(unique, name)
 	}
 
-	return names
+	return unique
 }
 
 // Produce a (possibly not unique) variable name from a type name.
@@ -515,10 +3286,14 @@ func typeNameToVarName(pref string, ty Type) string {
 	name := filter(ty.ToString(), unicode.IsLetter)
 
 	// More pleasing capitalisation.
-	for i, r := range name {
-		name = string(unicode.ToUpper(r)) + name[i+1:]
-		break
+	return pref + capitalise(name)
+}
+
+// Capitalise the first letter of a string.
+func capitalise(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+utf8.RuneLen(r):]
 	}
 
-	return pref + name
+	return s
 }
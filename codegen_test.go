@@ -0,0 +1,325 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Generate a complete source file with the fuzzers for a package, as
+// if it were written to the given file in the package directory, with
+// some other options. Returns the package and the code.
+func generatedCode(pattern, filename string, options CodeGenOptions, t *testing.T) (Package, string) {
+	pkgs, err := LoadPackages([]string{pattern})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := pkgs[0]
+
+	wanteds, errs := WantedFuzzersFromPackage(pkg)
+	if len(errs) > 0 {
+		t.Fatal(errorList("Could not extract fuzzers", errs))
+	}
+	fuzzers, errs := reconcileFuzzers(NewTypeEnv(pkg.Types, pkg.Files), InterfacesFromPackage(pkg), wanteds)
+	if len(errs) > 0 {
+		t.Fatal(errorList("Could not reconcile fuzzers", errs))
+	}
+
+	options.Complete = true
+	options.Filename = filepath.Join(pkg.Dir, filename)
+	options.PackageName = pkg.Name
+	code, errs := CodeGen(options, ImportsFromPackage(pkg), fuzzers)
+	if len(errs) > 0 {
+		t.Fatal(errorList("Could not generate code", errs))
+	}
+
+	return pkg, code
+}
+
+// Generate the fuzzers for a package in the testdata directory, and
+// copy the package, with its tests and the generated code, into a
+// module of its own. Returns the directory of the module.
+func generatedModule(name string, t *testing.T) string {
+	return generatedModuleWith(name, CodeGenOptions{}, t)
+}
+
+// Like generatedModule, but generating the fuzzers with some other
+// options.
+func generatedModuleWith(name string, options CodeGenOptions, t *testing.T) string {
+	if testing.Short() {
+		t.Skip("skipping compiling generated code in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("skipping compiling generated code without the go tool")
+	}
+
+	pkg, code := generatedCode("./testdata/"+name, "fuzz_generated.go", options, t)
+
+	dir := t.TempDir()
+	entries, err := os.ReadDir(pkg.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		src, err := os.ReadFile(filepath.Join(pkg.Dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		"fuzz_generated.go": code,
+		"go.mod":            "module example.com/" + name + "\n\ngo 1.22\n",
+	}
+	for filename, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// Run the go tool in a directory, with some extra environment
+// variables, returning its combined output.
+func goTool(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+	cmd.Env = append(cmd.Env, env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// Check that a failure is rendered as a regression test which passes
+// "go vet", reproduces the failure, and renders arguments as literals.
+func TestGeneratedRegressionTest(t *testing.T) {
+	dir := generatedModule("regression", t)
+
+	if out, err := goTool(dir, nil, "test", "-run", "TestFuzzLiteral"); err != nil {
+		t.Fatalf("Rendering literals failed:\n%s", out)
+	}
+
+	out, err := goTool(dir, []string{"FUZZ_REGRESSION_DIR=" + dir}, "test", "-run", "TestFuzzBroken")
+	if err == nil {
+		t.Fatal("Expected the broken implementation to fail.")
+	}
+	if !strings.Contains(out, "wrote regression test") {
+		t.Fatalf("Expected a regression test to be written:\n%s", out)
+	}
+
+	if out, err := goTool(dir, nil, "vet"); err != nil {
+		t.Fatalf("The regression test does not pass go vet:\n%s", out)
+	}
+	out, err = goTool(dir, nil, "test", "-run", "TestStoreRegression")
+	if err == nil || !strings.Contains(out, "inconsistent result in Len") {
+		t.Fatalf("Expected the regression test to reproduce the failure:\n%s", out)
+	}
+}
+
+// Check that failures are returned as the structured failure type,
+// with the failing operation, its results, and the trace filled in.
+func TestGeneratedFailures(t *testing.T) {
+	dir := generatedModule("failures", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Reporting failures failed:\n%s", out)
+	}
+}
+
+// Check that the fuzzer generated in the invariant-only mode, which
+// needs no reference implementation, returns the structured failure
+// type when an invariant is violated.
+func TestGeneratedInvariants(t *testing.T) {
+	dir := generatedModuleWith("invariants", CodeGenOptions{InvariantsOnly: true}, t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Checking invariants failed:\n%s", out)
+	}
+}
+
+// Check that an invariant checked against both implementations is
+// reported when only the reference implementation violates it.
+func TestGeneratedInvariantsBoth(t *testing.T) {
+	dir := generatedModule("both", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Checking invariants of both implementations failed:\n%s", out)
+	}
+}
+
+// Check that every instantiation of a generic interface gets a
+// fuzzer of its own, and that they can be in the same package.
+func TestGeneratedGenerics(t *testing.T) {
+	dir := generatedModule("generics", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Fuzzing instantiations failed:\n%s", out)
+	}
+}
+
+// Check that variadic arguments are spread when calling a method, and
+// that their number is bounded by the size.
+func TestGeneratedVariadic(t *testing.T) {
+	dir := generatedModule("variadic", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Generating variadic arguments failed:\n%s", out)
+	}
+}
+
+// Check that the fields of structs generated by default are generated
+// by their field generators, if they have them.
+func TestGeneratedFields(t *testing.T) {
+	dir := generatedModule("fields", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Generating fields failed:\n%s", out)
+	}
+}
+
+// Check that boundary values are generated with the probability given
+// by "@edge cases", and never when it is zero.
+func TestGeneratedEdgeCases(t *testing.T) {
+	dir := generatedModule("edges", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Generating boundary values failed:\n%s", out)
+	}
+}
+
+// Check that the methods of embedded interfaces, from another package
+// and from the same package, are fuzzed.
+func TestGeneratedEmbedded(t *testing.T) {
+	dir := generatedModule("embedded", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Fuzzing embedded interfaces failed:\n%s", out)
+	}
+}
+
+// Check that failures are shrunk by replaying them against fresh
+// implementations, each made with its own copy of the arguments.
+func TestGeneratedReplays(t *testing.T) {
+	dir := generatedModule("replays", t)
+	if out, err := goTool(dir, nil, "test", "-run", "TestFuzzReplays"); err != nil {
+		t.Fatalf("Replaying failures failed:\n%s", out)
+	}
+}
+
+// Check that a regression test for an implementation made with
+// arguments which may share memory gives each implementation its own
+// copy of them, and reproduces the failure.
+func TestGeneratedRegressionTestArgs(t *testing.T) {
+	dir := generatedModule("replays", t)
+
+	out, err := goTool(dir, []string{"FUZZ_REGRESSION_DIR=" + dir}, "test", "-run", "TestFuzzBroken")
+	if err == nil || !strings.Contains(out, "wrote regression test") {
+		t.Fatalf("Expected a regression test to be written:\n%s", out)
+	}
+
+	out, err = goTool(dir, nil, "test", "-run", "TestQueueRegression")
+	if err == nil || !strings.Contains(out, "inconsistent result in Len") {
+		t.Fatalf("Expected the regression test to reproduce the failure:\n%s", out)
+	}
+}
+
+// Check that values made by provided generators are not shrunk by the
+// default shrinkers, but default generated values are.
+func TestGeneratedShrinking(t *testing.T) {
+	dir := generatedModule("shrinking", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Shrinking failed:\n%s", out)
+	}
+}
+
+// Check that a callback kept by one operation is checked when a later
+// operation invokes it.
+func TestGeneratedCallbacks(t *testing.T) {
+	dir := generatedModule("callbacks", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Checking callbacks failed:\n%s", out)
+	}
+}
+
+// Check that native fuzzing stops once the input is used up, and
+// starts from the same operations as the test case, so that its seed
+// corpus finds the same failures.
+func TestGeneratedNative(t *testing.T) {
+	dir := generatedModule("native", t)
+	if out, err := goTool(dir, nil, "test", "-skip", "FuzzBrokenAdder"); err != nil {
+		t.Fatalf("Native fuzzing failed:\n%s", out)
+	}
+
+	// The seed corpus alone finds the failure, without fuzzing.
+	out, err := goTool(dir, nil, "test", "-run", "FuzzBrokenAdder")
+	if err == nil || !strings.Contains(out, "inconsistent result in Add") {
+		t.Fatalf("Expected the seed corpus to find the failure:\n%s", out)
+	}
+}
+
+// Check that an implementation which changes its arguments doesn't
+// change those of the other implementation, or of later replays.
+func TestGeneratedCopying(t *testing.T) {
+	dir := generatedModule("copying", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Copying arguments failed:\n%s", out)
+	}
+}
+
+// Check that returned values, and the elements of returned arrays,
+// are drawn from the pools as arguments.
+func TestGeneratedPools(t *testing.T) {
+	dir := generatedModule("pools", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Drawing from pools failed:\n%s", out)
+	}
+}
+
+// Check that values of an enumeration generated out of range are not
+// its constants.
+func TestGeneratedEnum(t *testing.T) {
+	dir := generatedModule("enums", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Generating enumerations failed:\n%s", out)
+	}
+}
+
+// Check that strings generated from regular expressions and grammars
+// match them, and that grammars give short strings even when every
+// rule can be picked many times.
+func TestGeneratedPatterns(t *testing.T) {
+	dir := generatedModule("patterns", t)
+	if out, err := goTool(dir, nil, "test", "-timeout", "1m"); err != nil {
+		t.Fatalf("Generating from patterns failed:\n%s", out)
+	}
+}
+
+// Check that the arguments of the reference implementation's
+// constructor are generated by their provided generators, and that
+// generators using the same function have states of their own.
+func TestGeneratedConstructors(t *testing.T) {
+	dir := generatedModule("constructors", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Generating constructor arguments failed:\n%s", out)
+	}
+}
+
+// Check that sized generators and default generators are given sizes
+// which grow over the run, up to the maximum.
+func TestGeneratedSized(t *testing.T) {
+	dir := generatedModule("sized", t)
+	if out, err := goTool(dir, nil, "test"); err != nil {
+		t.Fatalf("Generating sized values failed:\n%s", out)
+	}
+}
+
+// Check that the generated code for the example is up to date.
+func TestGeneratedExample(t *testing.T) {
+	pkg, code := generatedCode("./_examples", "store.generated.go", CodeGenOptions{}, t)
+
+	golden, err := os.ReadFile(filepath.Join(pkg.Dir, "store.generated.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != string(golden) {
+		t.Fatal("The generated code for the example is out of date; regenerate it as described in the README.")
+	}
+}
@@ -0,0 +1,15 @@
+module github.com/pusher/go-interface-fuzzer
+
+go 1.25.0
+
+require (
+	github.com/urfave/cli v1.22.17
+	golang.org/x/tools v0.47.0
+)
+
+require (
+	github.com/cpuguy83/go-md2man/v2 v2.0.7 // indirect
+	github.com/russross/blackfriday/v2 v2.1.0 // indirect
+	golang.org/x/mod v0.37.0 // indirect
+	golang.org/x/sync v0.21.0 // indirect
+)
@@ -9,6 +9,8 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/types"
+	"strings"
 )
 
 // A Function is a representation of a function name and type, which
@@ -21,32 +23,43 @@ type Function struct {
 	// The parameter types
 	Parameters []Type
 
+	// The parameter names, one for each parameter type. These are
+	// empty if the parameters are unnamed.
+	ParameterNames []string
+
 	// The output types
 	Returns []Type
+
+	// True if the final parameter is variadic. Its type is the
+	// slice type the arguments are collected into.
+	Variadic bool
 }
 
 // Type is a representation of a Go type. The concrete types are
-// ArrayType, BasicType, ChanType, MapType, PointerType, and
-// QualifiedType.
+// ArrayType, BasicType, ChanType, FuncType, InstanceType,
+// InterfaceType, MapType, PointerType, QualifiedType, and StructType.
 type Type interface {
 	// Return an unambiguous string rendition of the type.
 	ToString() string
 }
 
-// ArrayType is the type of arrays.
+// ArrayType is the type of arrays and slices.
 type ArrayType struct {
 	// The element type
 	ElementType Type
+
+	// The length, as a Go expression. This is empty for slices.
+	Length string
 }
 
 // ToString converts an ArrayType into a string of the form
-// "[](type)".
+// "[](type)" for slices, or "[length](type)" for arrays.
 func (ty *ArrayType) ToString() string {
 	if ty == nil {
 		return ""
 	}
 
-	tystr := fmt.Sprintf("[](%s)", ty.ElementType.ToString())
+	tystr := fmt.Sprintf("[%s](%s)", ty.Length, ty.ElementType.ToString())
 	return tystr
 }
 
@@ -66,16 +79,134 @@ func (ty *BasicType) ToString() string {
 type ChanType struct {
 	// The element type.
 	ElementType Type
+
+	// The direction values can be passed in.
+	Dir ChanDir
 }
 
+// ChanDir is the direction of a channel type.
+type ChanDir int
+
+// The directions of channels. The zero value is a bidirectional
+// channel.
+const (
+	ChanBoth ChanDir = iota
+	ChanSend
+	ChanRecv
+)
+
 // ToString converts a ChanType into a string of the form "chan
-// (type)".
+// (type)", "chan<- (type)", or "<-chan (type)".
 func (ty *ChanType) ToString() string {
 	if ty == nil {
 		return ""
 	}
 
-	tystr := fmt.Sprintf("chan (%s)", ty.ElementType.ToString())
+	var tystr string
+	switch ty.Dir {
+	case ChanSend:
+		tystr = fmt.Sprintf("chan<- (%s)", ty.ElementType.ToString())
+	case ChanRecv:
+		tystr = fmt.Sprintf("<-chan (%s)", ty.ElementType.ToString())
+	default:
+		tystr = fmt.Sprintf("chan (%s)", ty.ElementType.ToString())
+	}
+	return tystr
+}
+
+// FuncType is the type of functions.
+type FuncType struct {
+	// The parameter types.
+	Parameters []Type
+
+	// The result types.
+	Returns []Type
+
+	// True if the final parameter is variadic. Its type is the
+	// slice type the arguments are collected into.
+	Variadic bool
+}
+
+// ToString converts a FuncType into a string of the form
+// "func(type, ..., type) (type, ..., type)".
+func (ty *FuncType) ToString() string {
+	if ty == nil {
+		return ""
+	}
+
+	var params []string
+	for i, param := range ty.Parameters {
+		if ty.Variadic && i == len(ty.Parameters)-1 {
+			if slicety, ok := param.(*ArrayType); ok {
+				params = append(params, "..."+slicety.ElementType.ToString())
+				continue
+			}
+		}
+		params = append(params, param.ToString())
+	}
+
+	tystr := fmt.Sprintf("func(%s)", strings.Join(params, ", "))
+	if len(ty.Returns) > 0 {
+		var returns []string
+		for _, ret := range ty.Returns {
+			returns = append(returns, ret.ToString())
+		}
+		tystr = fmt.Sprintf("%s (%s)", tystr, strings.Join(returns, ", "))
+	}
+
+	return tystr
+}
+
+// InstanceType is the type of instantiations of generic types.
+type InstanceType struct {
+	// The generic type.
+	Type Type
+	// The type arguments.
+	TypeArgs []Type
+}
+
+// ToString converts an InstanceType into a string of the form
+// "type[type, ..., type]".
+func (ty *InstanceType) ToString() string {
+	if ty == nil {
+		return ""
+	}
+
+	var args []string
+	for _, arg := range ty.TypeArgs {
+		args = append(args, arg.ToString())
+	}
+
+	tystr := fmt.Sprintf("%s[%s]", ty.Type.ToString(), strings.Join(args, ", "))
+	return tystr
+}
+
+// InterfaceType is the type of anonymous interfaces.
+type InterfaceType struct {
+	// The methods.
+	Methods []Function
+
+	// The embedded interfaces.
+	Embedded []Type
+}
+
+// ToString converts an InterfaceType into a string of the form
+// "interface{name(type, ..., type) (type, ..., type); type}".
+func (ty *InterfaceType) ToString() string {
+	if ty == nil {
+		return ""
+	}
+
+	var members []string
+	for _, function := range ty.Methods {
+		functy := FuncType{Parameters: function.Parameters, Returns: function.Returns, Variadic: function.Variadic}
+		members = append(members, function.Name+strings.TrimPrefix(functy.ToString(), "func"))
+	}
+	for _, embedded := range ty.Embedded {
+		members = append(members, embedded.ToString())
+	}
+
+	tystr := fmt.Sprintf("interface{%s}", strings.Join(members, "; "))
 	return tystr
 }
 
@@ -123,6 +254,51 @@ func (ty *QualifiedType) ToString() string {
 	return tystr
 }
 
+// StructType is the type of anonymous structs.
+type StructType struct {
+	// The fields, in order.
+	Fields []Field
+}
+
+// Field is a field of a struct.
+type Field struct {
+	// The name of the field. For an embedded field this is the
+	// name of the type.
+	Name string
+
+	// The type of the field.
+	Type Type
+
+	// True if this is an embedded field.
+	Embedded bool
+
+	// The tag, as a Go string literal, if there is one.
+	Tag string
+}
+
+// ToString converts a StructType into a string of the form
+// "struct{name type; type; name type tag}".
+func (ty *StructType) ToString() string {
+	if ty == nil {
+		return ""
+	}
+
+	var fields []string
+	for _, field := range ty.Fields {
+		fieldstr := field.Type.ToString()
+		if !field.Embedded {
+			fieldstr = field.Name + " " + fieldstr
+		}
+		if field.Tag != "" {
+			fieldstr = fieldstr + " " + field.Tag
+		}
+		fields = append(fields, fieldstr)
+	}
+
+	tystr := fmt.Sprintf("struct{%s}", strings.Join(fields, "; "))
+	return tystr
+}
+
 // ToString converts a PointerType into a string of the form
 // "*(type)".
 func (ty *PointerType) ToString() string {
@@ -134,14 +310,27 @@ func (ty *PointerType) ToString() string {
 	return tystr
 }
 
+// An Interface is a representation of an interface declaration: the
+// methods declared in it, and the other interfaces it embeds.
+type Interface struct {
+	// The names of the type parameters, if this is generic.
+	TypeParams []string
+
+	// The methods declared directly in the interface.
+	Methods []Function
+
+	// The embedded interfaces.
+	Embedded []Type
+}
+
 // InterfacesFromAST extracts all interface declarations from the AST
 // of a file, as a map from names to interface decls.
-func InterfacesFromAST(theAST ast.Node) map[string][]Function {
+func InterfacesFromAST(theAST ast.Node) map[string]Interface {
 	if theAST == nil {
 		return nil
 	}
 
-	interfaces := make(map[string][]Function)
+	interfaces := make(map[string]Interface)
 
 	ast.Inspect(theAST, func(node ast.Node) bool {
 		switch tyspec := node.(type) {
@@ -151,7 +340,11 @@ func InterfacesFromAST(theAST ast.Node) map[string][]Function {
 			case *ast.InterfaceType:
 				functions, err := FunctionsFromInterfaceType(*ifacety)
 				if err == nil {
-					interfaces[name] = functions
+					interfaces[name] = Interface{
+						TypeParams: TypeParamsFromTypeSpec(*tyspec),
+						Methods:    functions,
+						Embedded:   EmbeddedFromInterfaceType(*ifacety),
+					}
 				}
 			}
 
@@ -168,8 +361,92 @@ func InterfacesFromAST(theAST ast.Node) map[string][]Function {
 	return interfaces
 }
 
+// FlattenInterface gets the full method set of an interface,
+// recursively expanding embedded interfaces. Embedded interfaces
+// declared in the same package are expanded from their declarations;
+// others are looked up in the type environment. Methods are only
+// included once, even if several embedded interfaces have them. If
+// the interface is generic, the method types refer to its type
+// parameters.
+func FlattenInterface(env *TypeEnv, interfaces map[string]Interface, name string) ([]Function, error) {
+	iface, ok := interfaces[name]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find interface '%s'", name)
+	}
+
+	var functions []Function
+	seen := make(map[string]bool)
+	add := func(function Function) {
+		if !seen[function.Name] {
+			seen[function.Name] = true
+			functions = append(functions, function)
+		}
+	}
+
+	expanding := map[string]bool{name: true}
+
+	var flatten func(Interface, map[string]Type) error
+	flatten = func(iface Interface, subst map[string]Type) error {
+		for _, function := range iface.Methods {
+			add(SubstituteFunction(function, subst))
+		}
+
+		for _, embedded := range iface.Embedded {
+			embedded = SubstituteType(embedded, subst)
+
+			// An interface declared in this package, which
+			// may be an instantiation of a generic interface.
+			var local *BasicType
+			var typeArgs []Type
+			switch x := embedded.(type) {
+			case *BasicType:
+				local = x
+			case *InstanceType:
+				local, _ = x.Type.(*BasicType)
+				typeArgs = x.TypeArgs
+			}
+			if local != nil {
+				if inner, ok := interfaces[string(*local)]; ok {
+					if expanding[string(*local)] {
+						return fmt.Errorf("interface '%s' embeds itself", string(*local))
+					}
+					if len(typeArgs) != len(inner.TypeParams) {
+						return fmt.Errorf("wrong number of type arguments for '%s'", embedded.ToString())
+					}
+					innerSubst := make(map[string]Type)
+					for i, param := range inner.TypeParams {
+						innerSubst[param] = typeArgs[i]
+					}
+					expanding[string(*local)] = true
+					err := flatten(inner, innerSubst)
+					expanding[string(*local)] = false
+					if err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			// Some other interface.
+			methods, err := env.MethodSet(embedded)
+			if err != nil {
+				return fmt.Errorf("couldn't expand embedded interface '%s': %s", embedded.ToString(), err)
+			}
+			for _, function := range methods {
+				add(function)
+			}
+		}
+
+		return nil
+	}
+
+	err := flatten(iface, nil)
+	return functions, err
+}
+
 // FunctionsFromInterfaceType tries to extract function declarations
-// from an ast.InterfaceType.
+// from an ast.InterfaceType. Embedded interfaces are skipped, see
+// EmbeddedFromInterfaceType.
 func FunctionsFromInterfaceType(ifacety ast.InterfaceType) ([]Function, error) {
 	var functions []Function
 	for _, field := range ifacety.Methods.List {
@@ -193,6 +470,8 @@ func FunctionsFromInterfaceType(ifacety ast.InterfaceType) ([]Function, error) {
 					function := Function{Name: name}
 					if funty.Params != nil {
 						function.Parameters = TypeListFromFieldList(*funty.Params)
+						function.ParameterNames = NamesFromFieldList(*funty.Params)
+						function.Variadic = isVariadic(*funty.Params)
 					}
 					if funty.Results != nil {
 						function.Returns = TypeListFromFieldList(*funty.Results)
@@ -209,18 +488,125 @@ func FunctionsFromInterfaceType(ifacety ast.InterfaceType) ([]Function, error) {
 	return functions, nil
 }
 
+// TypeParamsFromTypeSpec gets the names of the type parameters of a
+// type declaration.
+func TypeParamsFromTypeSpec(tyspec ast.TypeSpec) []string {
+	var params []string
+	if tyspec.TypeParams == nil {
+		return params
+	}
+
+	for _, field := range tyspec.TypeParams.List {
+		for _, name := range field.Names {
+			params = append(params, name.Name)
+		}
+	}
+
+	return params
+}
+
+// EmbeddedFromInterfaceType gets the types of the interfaces embedded
+// in an ast.InterfaceType.
+func EmbeddedFromInterfaceType(ifacety ast.InterfaceType) []Type {
+	var embedded []Type
+	for _, field := range ifacety.Methods.List {
+		if len(field.Names) != 0 {
+			continue
+		}
+
+		ty := TypeFromTypeExpr(field.Type)
+		if ty != nil {
+			embedded = append(embedded, ty)
+		}
+	}
+
+	return embedded
+}
+
+// Check if the final field in a parameter list is variadic.
+func isVariadic(fields ast.FieldList) bool {
+	if len(fields.List) == 0 {
+		return false
+	}
+
+	_, ok := fields.List[len(fields.List)-1].Type.(*ast.Ellipsis)
+	return ok
+}
+
 // TypeListFromFieldList gets the list of type names from an
-// ast.FieldList. Names are not returned.
+// ast.FieldList, with one type for each name, so "(a, b int)" has two
+// types. Names are not returned.
 func TypeListFromFieldList(fields ast.FieldList) []Type {
-	types := make([]Type, len(fields.List))
+	var types []Type
 
-	for i, field := range fields.List {
-		types[i] = TypeFromTypeExpr(field.Type)
+	for _, field := range fields.List {
+		ty := TypeFromTypeExpr(field.Type)
+		types = append(types, ty)
+		for i := 1; i < len(field.Names); i++ {
+			types = append(types, ty)
+		}
 	}
 
 	return types
 }
 
+// NamesFromFieldList gets the list of names from an ast.FieldList, in
+// the same order as TypeListFromFieldList. Unnamed fields have an
+// empty name.
+func NamesFromFieldList(fields ast.FieldList) []string {
+	var names []string
+
+	for _, field := range fields.List {
+		if len(field.Names) == 0 {
+			names = append(names, "")
+			continue
+		}
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+
+	return names
+}
+
+// FieldsFromFieldList gets the fields of a struct from an
+// ast.FieldList, with one Field for each name.
+func FieldsFromFieldList(fields ast.FieldList) []Field {
+	var structFields []Field
+
+	for _, field := range fields.List {
+		ty := TypeFromTypeExpr(field.Type)
+		tag := ""
+		if field.Tag != nil {
+			tag = field.Tag.Value
+		}
+
+		if len(field.Names) == 0 {
+			// The name of an embedded field is the
+			// unqualified type name.
+			namety := ty
+			if ptrty, ok := namety.(*PointerType); ok {
+				namety = ptrty.TargetType
+			}
+			if qualty, ok := namety.(*QualifiedType); ok {
+				namety = qualty.Type
+			}
+			if instty, ok := namety.(*InstanceType); ok {
+				namety = instty.Type
+			}
+			name := namety.ToString()
+			structFields = append(structFields, Field{Name: name, Type: ty, Embedded: true, Tag: tag})
+			continue
+		}
+
+		for _, name := range field.Names {
+			structFields = append(structFields, Field{Name: name.Name, Type: ty, Tag: tag})
+		}
+	}
+
+	return structFields
+}
+
 // TypeFromTypeExpr gets a type from an ast.Expr which is known to
 // represent a type.
 func TypeFromTypeExpr(ty ast.Expr) Type {
@@ -230,10 +616,23 @@ func TypeFromTypeExpr(ty ast.Expr) Type {
 		ty := BasicType(x.Name)
 		return &ty
 	case *ast.ArrayType:
+		ty := ArrayType{ElementType: TypeFromTypeExpr(x.Elt)}
+		if x.Len != nil {
+			ty.Length = types.ExprString(x.Len)
+		}
+		return &ty
+	case *ast.Ellipsis:
+		// Variadic arguments are collected into a slice.
 		ty := ArrayType{ElementType: TypeFromTypeExpr(x.Elt)}
 		return &ty
 	case *ast.ChanType:
 		ty := ChanType{ElementType: TypeFromTypeExpr(x.Value)}
+		switch x.Dir {
+		case ast.SEND:
+			ty.Dir = ChanSend
+		case ast.RECV:
+			ty.Dir = ChanRecv
+		}
 		return &ty
 	case *ast.MapType:
 		ty := MapType{KeyType: TypeFromTypeExpr(x.Key), ValueType: TypeFromTypeExpr(x.Value)}
@@ -241,6 +640,34 @@ func TypeFromTypeExpr(ty ast.Expr) Type {
 	case *ast.StarExpr:
 		ty := PointerType{TargetType: TypeFromTypeExpr(x.X)}
 		return &ty
+	case *ast.FuncType:
+		ty := FuncType{}
+		if x.Params != nil {
+			ty.Parameters = TypeListFromFieldList(*x.Params)
+			ty.Variadic = isVariadic(*x.Params)
+		}
+		if x.Results != nil {
+			ty.Returns = TypeListFromFieldList(*x.Results)
+		}
+		return &ty
+	case *ast.InterfaceType:
+		functions, _ := FunctionsFromInterfaceType(*x)
+		ty := InterfaceType{Methods: functions, Embedded: EmbeddedFromInterfaceType(*x)}
+		return &ty
+	case *ast.StructType:
+		ty := StructType{Fields: FieldsFromFieldList(*x.Fields)}
+		return &ty
+	case *ast.ParenExpr:
+		return TypeFromTypeExpr(x.X)
+	case *ast.IndexExpr:
+		ty := InstanceType{Type: TypeFromTypeExpr(x.X), TypeArgs: []Type{TypeFromTypeExpr(x.Index)}}
+		return &ty
+	case *ast.IndexListExpr:
+		ty := InstanceType{Type: TypeFromTypeExpr(x.X)}
+		for _, index := range x.Indices {
+			ty.TypeArgs = append(ty.TypeArgs, TypeFromTypeExpr(index))
+		}
+		return &ty
 	case *ast.SelectorExpr:
 		// x.X is an expression which resolves to the package
 		// name and x.Sel is the "selector", which is the
@@ -253,3 +680,72 @@ func TypeFromTypeExpr(ty ast.Expr) Type {
 
 	return nil
 }
+
+// SubstituteType replaces the type parameters named in a substitution
+// with the corresponding type arguments.
+func SubstituteType(ty Type, subst map[string]Type) Type {
+	if len(subst) == 0 {
+		return ty
+	}
+
+	switch x := ty.(type) {
+	case *BasicType:
+		if arg, ok := subst[string(*x)]; ok {
+			return arg
+		}
+	case *ArrayType:
+		return &ArrayType{ElementType: SubstituteType(x.ElementType, subst), Length: x.Length}
+	case *ChanType:
+		return &ChanType{ElementType: SubstituteType(x.ElementType, subst), Dir: x.Dir}
+	case *FuncType:
+		function := SubstituteFunction(Function{Parameters: x.Parameters, Returns: x.Returns}, subst)
+		return &FuncType{Parameters: function.Parameters, Returns: function.Returns, Variadic: x.Variadic}
+	case *InstanceType:
+		ity := InstanceType{Type: x.Type}
+		for _, arg := range x.TypeArgs {
+			ity.TypeArgs = append(ity.TypeArgs, SubstituteType(arg, subst))
+		}
+		return &ity
+	case *InterfaceType:
+		ity := InterfaceType{}
+		for _, function := range x.Methods {
+			ity.Methods = append(ity.Methods, SubstituteFunction(function, subst))
+		}
+		for _, embedded := range x.Embedded {
+			ity.Embedded = append(ity.Embedded, SubstituteType(embedded, subst))
+		}
+		return &ity
+	case *StructType:
+		sty := StructType{}
+		for _, field := range x.Fields {
+			field.Type = SubstituteType(field.Type, subst)
+			sty.Fields = append(sty.Fields, field)
+		}
+		return &sty
+	case *MapType:
+		return &MapType{KeyType: SubstituteType(x.KeyType, subst), ValueType: SubstituteType(x.ValueType, subst)}
+	case *PointerType:
+		return &PointerType{TargetType: SubstituteType(x.TargetType, subst)}
+	}
+
+	return ty
+}
+
+// SubstituteFunction replaces the type parameters named in a
+// substitution with the corresponding type arguments, in all of the
+// parameter and return types of a function.
+func SubstituteFunction(function Function, subst map[string]Type) Function {
+	if len(subst) == 0 {
+		return function
+	}
+
+	substituted := Function{Name: function.Name, ParameterNames: function.ParameterNames, Variadic: function.Variadic}
+	for _, ty := range function.Parameters {
+		substituted.Parameters = append(substituted.Parameters, SubstituteType(ty, subst))
+	}
+	for _, ty := range function.Returns {
+		substituted.Returns = append(substituted.Returns, SubstituteType(ty, subst))
+	}
+
+	return substituted
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+// Check that embedded interfaces declared in the same file are
+// expanded, and that overlapping methods only appear once.
+func TestFlattenInterfaceLocal(t *testing.T) {
+	src := `
+package example
+
+type Closer interface {
+	Close() error
+}
+
+type Getter interface {
+	Closer
+	Get(key string) int
+}
+
+type Store interface {
+	Getter
+	Closer
+	Put(key string, value int)
+}
+`
+	interfaces := parseInterfaces(src, t)
+
+	functions, err := FlattenInterface(nil, interfaces, "Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, function := range functions {
+		names = append(names, function.Name)
+	}
+
+	expected := []string{"Put", "Get", "Close"}
+	if len(names) != len(expected) {
+		expectedActual("Wrong method set.", expected, names, t)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			expectedActual("Wrong method set.", expected, names, t)
+		}
+	}
+}
+
+// Check that an embedded interface which cannot be found is an error.
+func TestFlattenInterfaceUnknown(t *testing.T) {
+	src := `
+package example
+
+type Store interface {
+	io.Closer
+}
+`
+	interfaces := parseInterfaces(src, t)
+
+	_, err := FlattenInterface(nil, interfaces, "Store")
+	if err == nil {
+		t.Fatal("Expected an error expanding an interface without type information.")
+	}
+}
+
+// Helper for extracting the interfaces from a source file.
+func parseInterfaces(src string, t *testing.T) map[string]Interface {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "example.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return InterfacesFromAST(file)
+}
+
+// Check that function types are parsed from the AST, including
+// variadic parameters.
+func TestFuncTypeFromAST(t *testing.T) {
+	src := `
+package example
+
+type Store interface {
+	ForEach(fn func(Message) bool)
+	Fold(fn func(int, ...string) (int, error))
+}
+`
+	interfaces := parseInterfaces(src, t)
+
+	expected := map[string]string{
+		"ForEach": "func(Message) (bool)",
+		"Fold":    "func(int, ...string) (int, error)",
+	}
+	for _, function := range interfaces["Store"].Methods {
+		if len(function.Parameters) != 1 {
+			t.Fatalf("Expected one parameter for %s.", function.Name)
+		}
+		actual := function.Parameters[0].ToString()
+		if actual != expected[function.Name] {
+			expectedActual("Wrong function type.", expected[function.Name], actual, t)
+		}
+	}
+}
+
+// Check that struct, interface, fixed-size array, and directional
+// channel types are parsed from the AST.
+func TestCompositeTypesFromAST(t *testing.T) {
+	src := `
+package example
+
+type Store interface {
+	Put(cells [4]byte, dims [N][2]int)
+	Pair(p struct {
+		A, B int
+		io.Reader
+		C string ` + "`json:\"c\"`" + `
+	})
+	Sink(out chan<- int, in <-chan string)
+	Wrap(r interface{ Read([]byte) (int, error); io.Closer })
+}
+`
+	interfaces := parseInterfaces(src, t)
+
+	expected := map[string][]string{
+		"Put":  {"[4](byte)", "[N]([2](int))"},
+		"Pair": {"struct{A int; B int; io.Reader; C string `json:\"c\"`}"},
+		"Sink": {"chan<- (int)", "<-chan (string)"},
+		"Wrap": {"interface{Read([](byte)) (int, error); io.Closer}"},
+	}
+	for _, function := range interfaces["Store"].Methods {
+		if len(function.Parameters) != len(expected[function.Name]) {
+			t.Fatalf("Wrong number of parameters for %s.", function.Name)
+		}
+		for i, param := range function.Parameters {
+			actual := param.ToString()
+			if actual != expected[function.Name][i] {
+				expectedActual("Wrong type.", expected[function.Name][i], actual, t)
+			}
+		}
+	}
+}
+
+// Check that grouped parameter and result names give one type for
+// each name, and that parameter names are kept.
+func TestGroupedParameters(t *testing.T) {
+	src := `
+package example
+
+type Board interface {
+	Move(from, to ID) (ok, moved bool)
+	Anon(ID, string) error
+}
+`
+	interfaces := parseInterfaces(src, t)
+
+	expected := map[string][]string{
+		"Move": {"from", "to"},
+		"Anon": {"", ""},
+	}
+	for _, function := range interfaces["Board"].Methods {
+		names := expected[function.Name]
+		if len(function.Parameters) != len(names) {
+			expectedActual("Wrong number of parameters.", len(names), len(function.Parameters), t)
+		}
+		if len(function.ParameterNames) != len(names) {
+			expectedActual("Wrong number of parameter names.", len(names), len(function.ParameterNames), t)
+		}
+		for i, name := range names {
+			if function.ParameterNames[i] != name {
+				expectedActual("Wrong parameter name.", name, function.ParameterNames[i], t)
+			}
+		}
+		if function.Name == "Move" && len(function.Returns) != 2 {
+			expectedActual("Wrong number of results.", 2, len(function.Returns), t)
+		}
+	}
+}
@@ -2,10 +2,9 @@ package main
 
 import (
 	"fmt"
-	"go/parser"
-	"go/token"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/urfave/cli"
@@ -23,41 +22,211 @@ func errorList(message string, errs []error) string {
 
 // Reconcile the wanted fuzzers with the interfaces. Complain if there
 // are any wanted fuzzers for which the interface decl isn't in the
-// file.
-func reconcileFuzzers(interfaces map[string][]Function, wanteds []WantedFuzzer) ([]Fuzzer, []error) {
+// package. Types in the fuzzers are resolved in the given environment,
+// which may be nil.
+func reconcileFuzzers(env *TypeEnv, interfaces map[string]Interface, wanteds []WantedFuzzer) ([]Fuzzer, []error) {
 	var errs []error
 
-	// Fuzzers are stored as a map from interface name to fuzzer.
+	// Fuzzers are stored as a map from fuzzer name to fuzzer.
 	// This allows rapid checking for duplicates.
 	fuzzers := make(map[string]Fuzzer)
 
 	for _, wanted := range wanteds {
-		_, present := fuzzers[wanted.InterfaceName]
-		if present {
-			errs = append(errs, fmt.Errorf("already have a fuzzer for '%s'", wanted.InterfaceName))
+		iface, ok := interfaces[wanted.InterfaceName]
+
+		if !ok {
+			errs = append(errs, fmt.Errorf("couldn't find interface '%s' in this package", wanted.InterfaceName))
 			continue
 		}
 
-		methods, ok := interfaces[wanted.InterfaceName]
+		// Include the methods of any embedded interfaces.
+		methods, err := FlattenInterface(env, interfaces, wanted.InterfaceName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
 
-		if !ok {
-			errs = append(errs, fmt.Errorf("couldn't find interface '%s' in this file", wanted.InterfaceName))
+		if err := checkParamGenerators(wanted, methods); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := checkGrammars(wanted); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := checkGeneratorExpressions(env, wanted); err != nil {
+			errs = append(errs, err)
+			continue
 		}
 
-		fuzzer := Fuzzer{Name: wanted.InterfaceName, Methods: methods, Wanted: wanted}
-		fuzzers[wanted.InterfaceName] = fuzzer
+		ifacety := BasicType(wanted.InterfaceName)
+		fuzzer := Fuzzer{Name: wanted.InterfaceName, Type: &ifacety, Methods: methods, Wanted: wanted, Env: env}
+
+		// Generic interfaces have one fuzzer per instantiation.
+		instances, err := instantiateFuzzer(iface, fuzzer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, instance := range instances {
+			_, present := fuzzers[instance.Name]
+			if present {
+				errs = append(errs, fmt.Errorf("already have a fuzzer for '%s'", instance.Name))
+				continue
+			}
+			fuzzers[instance.Name] = instance
+		}
+	}
+
+	// Get a slice out of the 'fuzzers' map, in a fixed order so
+	// the generated code is deterministic.
+	var names []string
+	for name := range fuzzers {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	// Get a slice out of the 'fuzzers' map
-	realfuzzers := make([]Fuzzer, len(fuzzers))
-	i := 0
-	for _, fuzzer := range fuzzers {
-		realfuzzers[i] = fuzzer
-		i++
+	realfuzzers := make([]Fuzzer, len(names))
+	for i, name := range names {
+		realfuzzers[i] = fuzzers[name]
 	}
 	return realfuzzers, errs
 }
 
+// Check that every parameter generator names a parameter of one of
+// the methods.
+func checkParamGenerators(wanted WantedFuzzer, methods []Function) error {
+	var keys []string
+	for key := range wanted.ParamGenerator {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		methodName, paramName, _ := strings.Cut(key, ".")
+
+		found := false
+		for _, function := range methods {
+			if function.Name != methodName {
+				continue
+			}
+			for _, name := range function.ParameterNames {
+				found = found || name == paramName
+			}
+			if !found {
+				return fmt.Errorf("method '%s' of '%s' has no parameter '%s'", methodName, wanted.InterfaceName, paramName)
+			}
+		}
+		if !found {
+			return fmt.Errorf("interface '%s' has no method '%s'", wanted.InterfaceName, methodName)
+		}
+	}
+
+	return nil
+}
+
+// Check that every rule of the grammar generators is defined, and can
+// be replaced by text alone.
+func checkGrammars(wanted WantedFuzzer) error {
+	var keys []string
+	for key := range wanted.Generator {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		grammar := wanted.Generator[key].Grammar
+		if grammar == nil {
+			continue
+		}
+		if _, err := grammarShortest(*grammar); err != nil {
+			return fmt.Errorf("invalid grammar for '%s': %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+// Check that the type of every expression generator, which is taken
+// from the conversion or composite literal it is, is really a type,
+// rather than a function called with one argument. Without type
+// information this can't be checked, which is an error.
+func checkGeneratorExpressions(env *TypeEnv, wanted WantedFuzzer) error {
+	for _, key := range sortedKeys(wanted.Generator) {
+		generator := wanted.Generator[key]
+		if generator.Expression == "" {
+			continue
+		}
+		if env == nil {
+			return fmt.Errorf("expression generator '%s' can't be checked without type information", generator.Expression)
+		}
+		if _, err := env.Resolve(generator.Type); err != nil {
+			return fmt.Errorf("expression generator '%s' is not a conversion or composite literal of a type: %s", generator.Expression, err)
+		}
+	}
+
+	return nil
+}
+
+// Get the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Instantiate a fuzzer for a generic interface once for each of the
+// wanted instantiations, substituting the type arguments into the
+// methods and the reference function. The fuzzer for a non-generic
+// interface is returned unchanged.
+func instantiateFuzzer(iface Interface, fuzzer Fuzzer) ([]Fuzzer, error) {
+	instantiations := fuzzer.Wanted.Instantiations
+
+	if len(iface.TypeParams) == 0 {
+		if len(instantiations) > 0 {
+			return nil, fmt.Errorf("cannot instantiate non-generic interface '%s'", fuzzer.Name)
+		}
+		return []Fuzzer{fuzzer}, nil
+	}
+
+	if len(instantiations) == 0 {
+		return nil, fmt.Errorf("generic interface '%s' must be given an '@instantiate' line", fuzzer.Name)
+	}
+
+	var instances []Fuzzer
+	for _, args := range instantiations {
+		if len(args) != len(iface.TypeParams) {
+			return nil, fmt.Errorf("'%s' has %d type parameters but was instantiated with %d", fuzzer.Name, len(iface.TypeParams), len(args))
+		}
+
+		subst := make(map[string]Type)
+		suffix := ""
+		for i, param := range iface.TypeParams {
+			subst[param] = args[i]
+			suffix = suffix + typeNameToVarName("", args[i])
+		}
+
+		instance := fuzzer
+		instance.Name = fuzzer.Name + suffix
+		instance.Type = &InstanceType{Type: fuzzer.Type, TypeArgs: args}
+		instance.TypeArgs = args
+		instance.Methods = nil
+		for _, function := range fuzzer.Methods {
+			instance.Methods = append(instance.Methods, SubstituteFunction(function, subst))
+		}
+		instance.Wanted.Reference = SubstituteFunction(fuzzer.Wanted.Reference, subst)
+		instance.Wanted.Reference.Returns = []Type{instance.Type}
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
 func main() {
 	var opts CodeGenOptions
 	var ifaceonly string
@@ -74,12 +243,12 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:        "filename, f",
-			Usage:       "Use `FILE` as the file name when automatically resolving imports (defaults to the filename of the source file)",
+			Usage:       "Use `FILE` as the file name when automatically resolving imports (defaults to a source file of the package); with multiple packages this is relative to each package directory",
 			Destination: &opts.Filename,
 		},
 		cli.StringFlag{
 			Name:        "package, p",
-			Usage:       "Use `NAME` as the package name (defaults to the package of the source file)",
+			Usage:       "Use `NAME` as the package name (defaults to the name of the package)",
 			Destination: &opts.PackageName,
 		},
 		cli.BoolFlag{
@@ -87,11 +256,21 @@ func main() {
 			Usage:       "Do not generate the TestFuzz... function",
 			Destination: &opts.NoTestCase,
 		},
+		cli.BoolFlag{
+			Name:        "no-native, N",
+			Usage:       "Do not generate the Fuzz...Native function",
+			Destination: &opts.NoNative,
+		},
 		cli.BoolFlag{
 			Name:        "no-default, D",
-			Usage:       "Do not generate the Fuzz... function, implies no-test-case",
+			Usage:       "Do not generate the Fuzz... function, implies no-test-case and no-native",
 			Destination: &opts.NoDefaultFuzz,
 		},
+		cli.BoolFlag{
+			Name:        "invariants-only, I",
+			Usage:       "Only generate the Fuzz...Invariants function, which checks invariants without a reference implementation",
+			Destination: &opts.InvariantsOnly,
+		},
 		cli.StringFlag{
 			Name:        "interface",
 			Usage:       "Ignore special comments and just generate a fuzz tester for the named interface, implies no-default",
@@ -105,61 +284,80 @@ func main() {
 	}
 	app.Action = func(c *cli.Context) error {
 		if len(c.Args()) < 1 {
-			return cli.NewExitError("Must specify a file to generate a fuzzer from.", 1)
+			return cli.NewExitError("Must specify a package to generate a fuzzer from.", 1)
+		}
+		if writeout && opts.Filename == "" {
+			return cli.NewExitError("When using -o a filename MUST be given to -f", 1)
 		}
 
-		filename := c.Args().Get(0)
-		fset := token.NewFileSet()
-		parsedFile, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
-
+		pkgs, err := LoadPackages(c.Args())
 		if err != nil {
-			return cli.NewExitError(fmt.Sprintf("Could not parse file: '%s'", err.Error()), 1)
+			return cli.NewExitError(err.Error(), 1)
 		}
 
-		// Extract all the interfaces
-		interfaces := InterfacesFromAST(parsedFile)
+		multiple := len(pkgs) > 1
+		generated := false
+		for _, pkg := range pkgs {
+			// Extract all the interfaces
+			interfaces := InterfacesFromPackage(pkg)
 
-		// Extract the wanted fuzzers
-		var wanteds []WantedFuzzer
-		var werrs []error
-		if ifaceonly == "" {
-			wanteds, werrs = WantedFuzzersFromAST(parsedFile)
-		} else {
-			// Default fuzzer for this interface.
-			wanteds = append(wanteds, WantedFuzzer{InterfaceName: ifaceonly})
-		}
-		if len(werrs) > 0 {
-			return cli.NewExitError(errorList("Found errors while extracting interface definitions", werrs), 1)
-		}
+			// Extract the wanted fuzzers
+			var wanteds []WantedFuzzer
+			var werrs []error
+			if ifaceonly == "" {
+				wanteds, werrs = WantedFuzzersFromPackage(pkg)
+			} else if _, ok := interfaces[ifaceonly]; ok || !multiple {
+				// Default fuzzer for this interface.
+				wanteds = append(wanteds, WantedFuzzer{InterfaceName: ifaceonly})
+			}
+			if len(werrs) > 0 {
+				return cli.NewExitError(errorList("Found errors while extracting interface definitions in "+pkg.Path, werrs), 1)
+			}
+			if len(wanteds) == 0 {
+				continue
+			}
 
-		// Reconcile the wanteds with the interfaces.
-		fuzzers, ferrs := reconcileFuzzers(interfaces, wanteds)
-		if len(ferrs) > 0 {
-			return cli.NewExitError(errorList("Found errors while determining wanted fuzz testers", ferrs), 1)
-		}
+			// Reconcile the wanteds with the interfaces.
+			env := NewTypeEnv(pkg.Types, pkg.Files)
+			fuzzers, ferrs := reconcileFuzzers(env, interfaces, wanteds)
+			if len(ferrs) > 0 {
+				return cli.NewExitError(errorList("Found errors while determining wanted fuzz testers in "+pkg.Path, ferrs), 1)
+			}
+
+			// Codegen
+			popts := opts
+			if popts.Filename == "" {
+				popts.Filename = pkg.Filenames[0]
+			} else {
+				popts.Filename, err = outputFilename(pkg, opts.Filename, multiple)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+			}
+			if popts.PackageName == "" {
+				popts.PackageName = pkg.Name
+			}
+			code, cerrs := CodeGen(popts, ImportsFromPackage(pkg), fuzzers)
+			if len(cerrs) > 0 {
+				return cli.NewExitError(errorList("Found some errors while generating code for "+pkg.Path, cerrs), 1)
+			}
 
-		// Codegen
-		if opts.Filename == "" {
 			if writeout {
-				return cli.NewExitError("When using -o a filename MUST be given to -f", 1)
+				err := ioutil.WriteFile(popts.Filename, []byte(code), 0644)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+			} else {
+				fmt.Println(code)
 			}
-			opts.Filename = filename
-		}
-		if opts.PackageName == "" {
-			opts.PackageName = parsedFile.Name.Name
-		}
-		code, cerrs := CodeGen(opts, parsedFile.Imports, fuzzers)
-		if len(cerrs) > 0 {
-			return cli.NewExitError(errorList("Found some errors while generating code", cerrs), 1)
+			generated = true
 		}
 
-		if writeout {
-			err := ioutil.WriteFile(opts.Filename, []byte(code), 0644)
-			if err != nil {
-				return cli.NewExitError(err.Error(), 1)
+		if !generated {
+			if ifaceonly != "" {
+				return cli.NewExitError(fmt.Sprintf("Could not find interface '%s'", ifaceonly), 1)
 			}
-		} else {
-			fmt.Println(code)
+			return cli.NewExitError("No fuzzers found", 1)
 		}
 
 		return nil
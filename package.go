@@ -0,0 +1,162 @@
+// Load packages.
+//
+// Fuzzers are generated per package: interfaces and special comments
+// may be spread across any of the files in a package, and all of the
+// fuzzers for a package are written to a single output file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Package is a loaded package: its name, location, and the parsed
+// files it consists of.
+type Package struct {
+	// The name of the package.
+	Name string
+
+	// The import path of the package.
+	Path string
+
+	// The directory containing the package source files.
+	Dir string
+
+	// The names of the package source files.
+	Filenames []string
+
+	// The parsed files, with comments.
+	Files []*ast.File
+
+	// The type-checked package. If the package has type errors, this
+	// may be incomplete.
+	Types *types.Package
+}
+
+// LoadPackages loads all of the packages matched by the given
+// patterns. A pattern may be anything understood by the go tool: an
+// import path, a directory, or a pattern such as "./...". A pattern
+// naming a single Go source file loads the whole package containing
+// that file.
+func LoadPackages(patterns []string) ([]Package, error) {
+	var queries []string
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, ".go") {
+			queries = append(queries, "file="+pattern)
+		} else {
+			queries = append(queries, pattern)
+		}
+	}
+
+	// Dependencies are loaded so that the packages are type
+	// checked from source, rather than compiled by the go tool,
+	// which turns type errors into fatal list errors.
+	cfg := packages.Config{Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedImports | packages.NeedDeps | packages.NeedTypes}
+	loaded, err := packages.Load(&cfg, queries...)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	var pkgs []Package
+	for _, lpkg := range loaded {
+		for _, perr := range lpkg.Errors {
+			// Type errors are not fatal: packages which use
+			// generated code (such as the fuzzers!) may not
+			// type check before it has been generated.
+			if perr.Kind != packages.TypeError {
+				errs = append(errs, perr)
+			}
+		}
+
+		pkg := Package{
+			Name:      lpkg.Name,
+			Path:      lpkg.PkgPath,
+			Files:     lpkg.Syntax,
+			Filenames: lpkg.GoFiles,
+			Types:     lpkg.Types,
+		}
+		if len(lpkg.GoFiles) > 0 {
+			pkg.Dir = filepath.Dir(lpkg.GoFiles[0])
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	if len(errs) > 0 {
+		return pkgs, errors.New(errorList("Could not load packages", errs))
+	}
+
+	return pkgs, nil
+}
+
+// InterfacesFromPackage extracts all interface declarations from all
+// of the files in a package.
+func InterfacesFromPackage(pkg Package) map[string]Interface {
+	interfaces := make(map[string]Interface)
+
+	for _, file := range pkg.Files {
+		for name, iface := range InterfacesFromAST(file) {
+			interfaces[name] = iface
+		}
+	}
+
+	return interfaces
+}
+
+// WantedFuzzersFromPackage extracts all wanted fuzzers from comments
+// in all of the files in a package.
+func WantedFuzzersFromPackage(pkg Package) (wanteds []WantedFuzzer, errs []error) {
+	for _, file := range pkg.Files {
+		fwanteds, ferrs := WantedFuzzersFromAST(file)
+		wanteds = append(wanteds, fwanteds...)
+		errs = append(errs, ferrs...)
+	}
+
+	return wanteds, errs
+}
+
+// ImportsFromPackage gets the imports of all of the files in a
+// package, without duplicates.
+func ImportsFromPackage(pkg Package) []*ast.ImportSpec {
+	var imports []*ast.ImportSpec
+	seen := make(map[string]bool)
+
+	for _, file := range pkg.Files {
+		for _, iport := range file.Imports {
+			key := iport.Path.Value
+			if iport.Name != nil {
+				key = iport.Name.Name + " " + key
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			imports = append(imports, iport)
+		}
+	}
+
+	return imports
+}
+
+// Get the name of the file to write the output for a package to,
+// given the -f flag. With a single package the name is used as-is,
+// but with multiple packages it is taken to be relative to each
+// package's directory.
+func outputFilename(pkg Package, filename string, multiple bool) (string, error) {
+	if !multiple {
+		return filename, nil
+	}
+
+	if filename != filepath.Base(filename) {
+		return "", fmt.Errorf("when generating fuzzers for multiple packages the filename '%s' must not contain a directory", filename)
+	}
+
+	return filepath.Join(pkg.Dir, filename), nil
+}
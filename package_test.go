@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Check that a package which refers to a fuzzer which has not been
+// generated yet can still be loaded.
+func TestLoadPackagesUndefined(t *testing.T) {
+	pkgs, err := LoadPackages([]string{"./testdata/undefined"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("Expected one package, got %d.", len(pkgs))
+	}
+
+	pkg := pkgs[0]
+	if pkg.Name != "undefined" {
+		expectedActual("Wrong package name.", "undefined", pkg.Name, t)
+	}
+	if len(pkg.Files) != 2 {
+		expectedActual("Wrong number of files.", 2, len(pkg.Files), t)
+	}
+	if pkg.Types == nil || pkg.Types.Scope().Lookup("Store") == nil {
+		t.Fatal("Expected the package to be type checked.")
+	}
+	if _, ok := InterfacesFromPackage(pkg)["Store"]; !ok {
+		t.Fatal("Expected to find the Store interface.")
+	}
+}
+
+// Check that naming a source file loads the whole package, and that
+// several packages can be loaded at once.
+func TestLoadPackagesPatterns(t *testing.T) {
+	pkgs, err := LoadPackages([]string{"./testdata/undefined/use.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || len(pkgs[0].Files) != 2 {
+		t.Fatal("Expected a source file to load the whole package.")
+	}
+	if filepath.Base(pkgs[0].Dir) != "undefined" {
+		expectedActual("Wrong package directory.", "undefined", filepath.Base(pkgs[0].Dir), t)
+	}
+
+	pkgs, err = LoadPackages([]string{"./testdata/undefined", "./testdata/imports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 {
+		expectedActual("Wrong number of packages.", 2, len(pkgs), t)
+	}
+}
+
+// Check that the imports of a package are gathered from all of its
+// files, without duplicates, keeping differently named imports apart.
+func TestImportsFromPackage(t *testing.T) {
+	pkgs, err := LoadPackages([]string{"./testdata/imports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var imports []string
+	for _, iport := range ImportsFromPackage(pkgs[0]) {
+		imports = append(imports, generateImport(iport))
+	}
+	sort.Strings(imports)
+
+	expected := []string{`import "fmt"`, `import "strings"`, `import str "strings"`}
+	if !reflect.DeepEqual(imports, expected) {
+		expectedActual("Wrong imports.", expected, imports, t)
+	}
+}
+
+// Check that the output file is only made relative to the package
+// directory when there are several packages.
+func TestOutputFilename(t *testing.T) {
+	pkg := Package{Dir: filepath.Join("src", "store")}
+
+	filename, err := outputFilename(pkg, filepath.Join("out", "fuzz.go"), false)
+	if err != nil || filename != filepath.Join("out", "fuzz.go") {
+		expectedActual("Wrong filename for a single package.", filepath.Join("out", "fuzz.go"), filename, t)
+	}
+
+	filename, err = outputFilename(pkg, "fuzz.go", true)
+	if err != nil || filename != filepath.Join("src", "store", "fuzz.go") {
+		expectedActual("Wrong filename for multiple packages.", filepath.Join("src", "store", "fuzz.go"), filename, t)
+	}
+
+	if _, err := outputFilename(pkg, filepath.Join("out", "fuzz.go"), true); err == nil {
+		t.Fatal("Expected an error for a directory with multiple packages.")
+	}
+}
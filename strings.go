@@ -51,6 +51,50 @@ func matchPrefix(s, prefix string) (string, bool) {
 	return s, false
 }
 
+// Check if a string starts with a keyword, followed by whitespace
+// rather than more of the same word, and if so return the rest.
+func matchKeyword(s, keyword string) (string, bool) {
+	rest := strings.TrimPrefix(s, keyword)
+	if rest == s || rest == "" || !unicode.IsSpace(rune(rest[0])) {
+		return s, false
+	}
+	return strings.TrimLeftFunc(rest, unicode.IsSpace), true
+}
+
+// Find the index of the bracket closing the one at the start of the
+// string, skipping over any string literals. Returns -1 if the string
+// does not start with the opening bracket or it is never closed.
+func matchBracket(s string, open, close rune) int {
+	depth := 0
+	var quote rune
+	escaped := false
+	for i, chr := range s {
+		switch {
+		case i == 0 && chr != open:
+			return -1
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if chr == '\\' && quote == '"' {
+				escaped = true
+			} else if chr == quote {
+				quote = 0
+			}
+		case chr == '"' || chr == '`':
+			quote = chr
+		case chr == open:
+			depth++
+		case chr == close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
 // Return a prefix and a suffix where the prefix contains only allowed
 // characters.
 func takeWhileIn(s, allowed string) (string, string) {
@@ -0,0 +1,46 @@
+package both
+
+/*
+@fuzz interface: Counter
+@known correct: newReference
+@invariant both: %var.(cached).cacheValid()
+*/
+type Counter interface {
+	Add(n int) int
+	Total() int
+}
+
+// cached is a counter which caches its total.
+type cached interface {
+	cacheValid() bool
+}
+
+// reference forgets to update its cache.
+type reference struct {
+	total, cache int
+}
+
+func newReference() Counter { return &reference{} }
+
+func (c *reference) Add(n int) int {
+	c.total += n
+	return c.total
+}
+
+func (c *reference) Total() int       { return c.total }
+func (c *reference) cacheValid() bool { return c.cache == c.total }
+
+type counter struct {
+	total, cache int
+}
+
+func newCounter() Counter { return &counter{} }
+
+func (c *counter) Add(n int) int {
+	c.total += n
+	c.cache = c.total
+	return c.total
+}
+
+func (c *counter) Total() int       { return c.cache }
+func (c *counter) cacheValid() bool { return c.cache == c.total }
@@ -0,0 +1,28 @@
+package both
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestFuzzReferenceInvariant(t *testing.T) {
+	err := FuzzCounter(newCounter, rand.New(rand.NewSource(0)), 100)
+
+	var failure *CounterFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if failure.Reason != "invariant violated by reference implementation: %var.(cached).cacheValid()" {
+		t.Errorf("wrong reason %q", failure.Reason)
+	}
+	if failure.Method != "Add" || failure.Expected != nil || failure.Actual != nil {
+		t.Errorf("expected Add to violate the invariant, got %s with results %v and %v", failure.Method, failure.Expected, failure.Actual)
+	}
+}
+
+func TestFuzzTestInvariant(t *testing.T) {
+	if err := FuzzCounterWith(newCounter(), newCounter(), rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+package callbacks
+
+type ID int
+
+/*
+@fuzz interface: Bus
+@known correct: newBus
+*/
+type Bus interface {
+	Subscribe(handler func(ID))
+	Publish(id ID)
+}
+
+type bus struct {
+	handlers []func(ID)
+}
+
+func newBus() Bus { return &bus{} }
+
+func (b *bus) Subscribe(handler func(ID)) {
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *bus) Publish(id ID) {
+	for _, handler := range b.handlers {
+		handler(id)
+	}
+}
+
+// brokenBus only remembers the latest handler.
+type brokenBus struct {
+	handler func(ID)
+}
+
+func (b *brokenBus) Subscribe(handler func(ID)) {
+	b.handler = handler
+}
+
+func (b *brokenBus) Publish(id ID) {
+	if b.handler != nil {
+		b.handler(id)
+	}
+}
@@ -0,0 +1,25 @@
+package callbacks
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestFuzzBus(t *testing.T) {
+	if err := FuzzBus(newBus, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFuzzBroken(t *testing.T) {
+	err := FuzzBus(func() Bus { return &brokenBus{} }, rand.New(rand.NewSource(0)), 100)
+
+	var failure *BusFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if failure.Reason != "inconsistent callback invocations in Subscribe" || failure.Method != "Publish" {
+		t.Fatalf("expected a callback kept by Subscribe to be invoked inconsistently by Publish, got %v", err)
+	}
+}
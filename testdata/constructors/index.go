@@ -0,0 +1,38 @@
+package constructors
+
+import (
+	"math/rand"
+	"strconv"
+)
+
+type Prefix string
+
+type Count int
+
+/*
+@fuzz interface: Index
+@known correct: newIndex Prefix Count
+@generator regex: Prefix "[a-z]{2}"
+@generator: ! nextCount Count state=Count(10)
+@generator Name.id: ! nextCount state=Count(1000)
+*/
+type Index interface {
+	Name(id Count) string
+	Has(count Count) bool
+}
+
+type index struct {
+	prefix Prefix
+	size   Count
+}
+
+func newIndex(prefix Prefix, size Count) Index { return index{prefix: prefix, size: size} }
+
+func (i index) Name(id Count) string { return string(i.prefix) + strconv.Itoa(int(id)) }
+
+func (i index) Has(count Count) bool { return count < i.size }
+
+// nextCount generates consecutive counts.
+func nextCount(rand *rand.Rand, state Count) (Count, Count) {
+	return state, state + 1
+}
@@ -0,0 +1,50 @@
+package constructors
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+var prefixPattern = regexp.MustCompile(`^[a-z]{2}$`)
+
+// checkedIndex checks that each generator using nextCount counts up
+// from its own initial state.
+type checkedIndex struct {
+	index
+	t     *testing.T
+	ids   *Count
+	count *Count
+}
+
+func (i checkedIndex) Name(id Count) string {
+	if id != *i.ids {
+		i.t.Errorf("expected id %d, got %d", *i.ids, id)
+	}
+	*i.ids = id + 1
+	return i.index.Name(id)
+}
+
+func (i checkedIndex) Has(count Count) bool {
+	if count != *i.count {
+		i.t.Errorf("expected count %d, got %d", *i.count, count)
+	}
+	*i.count = count + 1
+	return i.index.Has(count)
+}
+
+func TestFuzzConstructors(t *testing.T) {
+	ids, count := Count(1000), Count(10)
+	makeTest := func(prefix Prefix, size Count) Index {
+		if !prefixPattern.MatchString(string(prefix)) {
+			t.Errorf("prefix %q does not match its regular expression", prefix)
+		}
+		if size != 10 {
+			t.Errorf("expected size 10, got %d", size)
+		}
+		return checkedIndex{index: newIndex(prefix, size).(index), t: t, ids: &ids, count: &count}
+	}
+	if err := FuzzIndex(makeTest, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+}
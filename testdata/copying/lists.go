@@ -0,0 +1,59 @@
+package copying
+
+import "slices"
+
+/*
+@fuzz interface: Lists
+@known correct: newLists
+*/
+type Lists interface {
+	Reverse(xs []int) []int
+	Sum(xs []int) int
+}
+
+// lists changes its arguments: it reverses in place, and clears a
+// slice once it has been summed.
+type lists struct{}
+
+func newLists() Lists { return lists{} }
+
+func (lists) Reverse(xs []int) []int {
+	for i, j := 0, len(xs)-1; i < j; i, j = i+1, j-1 {
+		xs[i], xs[j] = xs[j], xs[i]
+	}
+	return xs
+}
+
+func (lists) Sum(xs []int) int {
+	sum := 0
+	for _, x := range xs {
+		sum += x
+	}
+	clear(xs)
+	return sum
+}
+
+// copyingLists leaves its arguments alone.
+type copyingLists struct{}
+
+func (copyingLists) Reverse(xs []int) []int {
+	return lists{}.Reverse(slices.Clone(xs))
+}
+
+func (copyingLists) Sum(xs []int) int {
+	sum := 0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
+}
+
+// brokenLists gets the sum of more than two numbers wrong.
+type brokenLists struct{ copyingLists }
+
+func (brokenLists) Sum(xs []int) int {
+	if len(xs) > 2 {
+		return 0
+	}
+	return copyingLists{}.Sum(xs)
+}
@@ -0,0 +1,31 @@
+package copying
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestFuzzLists(t *testing.T) {
+	err := FuzzLists(func() Lists { return copyingLists{} }, rand.New(rand.NewSource(0)), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFuzzBroken(t *testing.T) {
+	err := FuzzLists(func() Lists { return brokenLists{} }, rand.New(rand.NewSource(0)), 100)
+
+	var failure *ListsFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+
+	// The arguments of the failure must not have been cleared by
+	// the reference implementation, in the first run or a replay.
+	xs, _ := failure.Args[0].([]int)
+	sum := copyingLists{}.Sum(xs)
+	if failure.Method != "Sum" || len(xs) <= 2 || sum == 0 {
+		t.Fatalf("expected a failure with the arguments it was found with, got %v", err)
+	}
+}
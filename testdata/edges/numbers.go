@@ -0,0 +1,32 @@
+package edges
+
+/*
+@fuzz interface: Numbers
+@known correct: newNumbers
+@edge cases: 0.5
+*/
+type Numbers interface {
+	Int(n int) int
+	Uint(n uint64) int
+	Float(f float64) int
+}
+
+/*
+@fuzz interface: Uniform
+@known correct: newUniform
+@edge cases: 0
+*/
+type Uniform interface {
+	Int(n int) int
+	Float(f float64) int
+}
+
+type numbers struct{}
+
+func newNumbers() Numbers { return numbers{} }
+
+func newUniform() Uniform { return numbers{} }
+
+func (numbers) Int(n int) int       { return 0 }
+func (numbers) Uint(n uint64) int   { return 0 }
+func (numbers) Float(f float64) int { return 0 }
@@ -0,0 +1,73 @@
+package edges
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// recordedNumbers records the boundary values it is given.
+type recordedNumbers struct {
+	numbers
+	seen map[string]bool
+}
+
+func (v recordedNumbers) Int(n int) int {
+	switch n {
+	case -1:
+		v.seen["-1"] = true
+	case math.MaxInt:
+		v.seen["MaxInt"] = true
+	case math.MinInt:
+		v.seen["MinInt"] = true
+	}
+	return v.numbers.Int(n)
+}
+
+func (v recordedNumbers) Uint(n uint64) int {
+	if n == math.MaxUint64 {
+		v.seen["MaxUint64"] = true
+	}
+	return v.numbers.Uint(n)
+}
+
+func (v recordedNumbers) Float(f float64) int {
+	switch {
+	case math.IsNaN(f):
+		v.seen["NaN"] = true
+	case math.IsInf(f, 1):
+		v.seen["+Inf"] = true
+	case math.IsInf(f, -1):
+		v.seen["-Inf"] = true
+	case f == 0 && math.Signbit(f):
+		v.seen["-0"] = true
+	}
+	return v.numbers.Float(f)
+}
+
+// None of these are generated uniformly.
+var boundaries = []string{"-1", "MaxInt", "MinInt", "MaxUint64", "NaN", "+Inf", "-Inf", "-0"}
+
+func TestFuzzEdgeCases(t *testing.T) {
+	seen := make(map[string]bool)
+	makeTest := func() Numbers { return recordedNumbers{seen: seen} }
+	if err := FuzzNumbers(makeTest, rand.New(rand.NewSource(0)), 1000); err != nil {
+		t.Fatal(err)
+	}
+	for _, boundary := range boundaries {
+		if !seen[boundary] {
+			t.Errorf("the boundary value %s was never generated", boundary)
+		}
+	}
+}
+
+func TestFuzzNoEdgeCases(t *testing.T) {
+	seen := make(map[string]bool)
+	makeTest := func() Uniform { return recordedNumbers{seen: seen} }
+	if err := FuzzUniform(makeTest, rand.New(rand.NewSource(0)), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) > 0 {
+		t.Errorf("boundary values %v were generated with edge cases disabled", seen)
+	}
+}
@@ -0,0 +1,58 @@
+package embedded
+
+import (
+	"errors"
+	"io"
+)
+
+type Reader interface {
+	Read(n int) string
+}
+
+/*
+@fuzz interface: File
+@known correct: newFile
+*/
+type File interface {
+	io.Closer
+	Reader
+	Write(s string) int
+}
+
+type file struct {
+	data   string
+	closed bool
+}
+
+func newFile() File { return &file{} }
+
+func (f *file) Close() error {
+	if f.closed {
+		return errors.New("already closed")
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *file) Read(n int) string {
+	if n < 0 || n > len(f.data) {
+		n = len(f.data)
+	}
+	return f.data[:n]
+}
+
+func (f *file) Write(s string) int {
+	if f.closed {
+		return 0
+	}
+	f.data += s
+	return len(s)
+}
+
+// reopeningFile can be closed any number of times.
+type reopeningFile struct{ file }
+
+func (f *reopeningFile) Close() error {
+	f.closed = true
+	return nil
+}
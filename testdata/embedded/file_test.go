@@ -0,0 +1,25 @@
+package embedded
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestFuzzEmbedded(t *testing.T) {
+	if err := FuzzFile(newFile, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFuzzEmbeddedBroken(t *testing.T) {
+	err := FuzzFile(func() File { return &reopeningFile{} }, rand.New(rand.NewSource(0)), 100)
+
+	var failure *FileFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if failure.Method != "Close" {
+		t.Errorf("expected the embedded Close to fail, got %s", failure.Method)
+	}
+}
@@ -0,0 +1,27 @@
+package enums
+
+type Mode int
+
+const (
+	ModeOff Mode = iota
+	ModeOn
+	ModeAuto
+)
+
+/*
+@fuzz interface: Switch
+@known correct: newSwitch
+@enum: Mode 1
+*/
+type Switch interface {
+	Set(m Mode) bool
+}
+
+// switchState accepts only the known modes.
+type switchState struct{}
+
+func newSwitch() Switch { return switchState{} }
+
+func (switchState) Set(m Mode) bool {
+	return m == ModeOff || m == ModeOn || m == ModeAuto
+}
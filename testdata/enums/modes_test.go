@@ -0,0 +1,32 @@
+package enums
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// countingSwitch counts the known modes it is set to.
+type countingSwitch struct {
+	switchState
+	known *int
+}
+
+func (s countingSwitch) Set(m Mode) bool {
+	ok := s.switchState.Set(m)
+	if ok {
+		*s.known++
+	}
+	return ok
+}
+
+func TestFuzzOutOfRange(t *testing.T) {
+	known := 0
+	makeTest := func() Switch { return countingSwitch{known: &known} }
+
+	if err := FuzzSwitch(makeTest, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+	if known > 0 {
+		t.Fatalf("expected values generated out of range not to be constants, got %d constants", known)
+	}
+}
@@ -0,0 +1,32 @@
+package failures
+
+/*
+@fuzz interface: Counter
+@known correct: newCounter uint8
+@invariant: %var.Total() <= 300
+*/
+type Counter interface {
+	Add(n uint8) int
+	Total() int
+}
+
+type counter struct {
+	total int
+}
+
+func newCounter(start uint8) Counter { return &counter{total: int(start)} }
+
+func (c *counter) Add(n uint8) int {
+	c.total += int(n)
+	return c.total
+}
+
+func (c *counter) Total() int { return c.total }
+
+// brokenCounter adds one too many.
+type brokenCounter struct{ counter }
+
+func (c *brokenCounter) Add(n uint8) int {
+	c.total += int(n) + 1
+	return c.total
+}
@@ -0,0 +1,79 @@
+package failures
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// Find the failure of an implementation.
+func failure(t *testing.T, makeTest func(uint8) Counter) *CounterFuzzFailure {
+	err := FuzzCounter(makeTest, rand.New(rand.NewSource(0)), 100)
+
+	var failure *CounterFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if failure.MaxOps != 100 {
+		t.Errorf("expected 100 maximum operations, got %d", failure.MaxOps)
+	}
+	if len(failure.MakeArgs) != 1 {
+		t.Fatalf("expected one argument to makeTest, got %v", failure.MakeArgs)
+	}
+	if _, ok := failure.MakeArgs[0].(uint8); !ok {
+		t.Errorf("expected a uint8 argument to makeTest, got %T", failure.MakeArgs[0])
+	}
+	if failure.Index != len(failure.Trace)-1 {
+		t.Errorf("expected the last of %d operations to fail, got %d", len(failure.Trace), failure.Index)
+	}
+	if failure.HasSeed {
+		t.Error("expected no seed")
+	}
+	return failure
+}
+
+func TestFailureResult(t *testing.T) {
+	f := failure(t, func(start uint8) Counter { return &brokenCounter{counter{total: int(start)}} })
+
+	if f.Reason != "inconsistent result in Add" {
+		t.Errorf("wrong reason %q", f.Reason)
+	}
+	if f.Method != "Add" || f.Trace[f.Index].Method != "Add" {
+		t.Errorf("expected Add to fail, got %s", f.Method)
+	}
+	if len(f.Args) != 1 {
+		t.Fatalf("expected one argument, got %v", f.Args)
+	}
+	if _, ok := f.Args[0].(uint8); !ok {
+		t.Errorf("expected a uint8 argument, got %T", f.Args[0])
+	}
+	if len(f.Expected) != 1 || len(f.Actual) != 1 || f.Actual[0].(int) != f.Expected[0].(int)+1 {
+		t.Errorf("wrong results, expected %v and got %v", f.Expected, f.Actual)
+	}
+}
+
+func TestFailureInvariant(t *testing.T) {
+	f := failure(t, newCounter)
+
+	if f.Reason != "invariant violated: %var.Total() <= 300" {
+		t.Errorf("wrong reason %q", f.Reason)
+	}
+	if f.Expected != nil || f.Actual != nil {
+		t.Errorf("expected no results, got %v and %v", f.Expected, f.Actual)
+	}
+}
+
+func TestFailureWith(t *testing.T) {
+	err := FuzzCounterWith(newCounter(0), &brokenCounter{}, rand.New(rand.NewSource(0)), 100)
+
+	var f *CounterFuzzFailure
+	if !errors.As(err, &f) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if f.MaxOps != 0 || f.MakeArgs != nil {
+		t.Errorf("expected no maximum operations or arguments to makeTest, got %d and %v", f.MaxOps, f.MakeArgs)
+	}
+	if len(f.Trace) == 0 || f.Index != len(f.Trace)-1 || f.Method != "Add" {
+		t.Errorf("expected the last operation, Add, to fail, got %d of %v", f.Index, f.Trace)
+	}
+}
@@ -0,0 +1,58 @@
+package fields
+
+import (
+	"math/rand"
+	"strings"
+)
+
+type ID int
+
+type Point struct {
+	ID    ID
+	X     int
+	Y     int
+	Label string
+	Z     int
+}
+
+/*
+@fuzz interface: Canvas
+@known correct: newCanvas
+@size: 5
+@generator field: Point.ID ! nextID state=ID(1)
+@generator field: Point.X generateX
+@generator field: Point.Y expr -%rand.Intn(10)
+@generator field: Point.Label ~ generateLabel
+*/
+type Canvas interface {
+	Draw(p Point) int
+	Move(p *Point) int
+}
+
+// generateX generates coordinates between 100 and 109.
+func generateX(rand *rand.Rand) int {
+	return 100 + rand.Intn(10)
+}
+
+// generateLabel generates labels of exactly the size.
+func generateLabel(rand *rand.Rand, size int) string {
+	return "L" + strings.Repeat("x", size)
+}
+
+// nextID generates consecutive IDs.
+func nextID(rand *rand.Rand, state ID) (ID, ID) {
+	return state, state + 1
+}
+
+type canvas struct{}
+
+func newCanvas() Canvas { return canvas{} }
+
+func (canvas) Draw(p Point) int { return p.X + p.Y }
+
+func (canvas) Move(p *Point) int {
+	if p == nil {
+		return 0
+	}
+	return p.X - p.Y
+}
@@ -0,0 +1,58 @@
+package fields
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// checkedCanvas checks that the fields with generators were generated
+// by them.
+type checkedCanvas struct {
+	canvas
+	t      *testing.T
+	nextID *ID
+	others *bool
+}
+
+func (c checkedCanvas) check(p Point) {
+	if p.ID != *c.nextID {
+		c.t.Errorf("expected ID %d, got %d", *c.nextID, p.ID)
+	}
+	*c.nextID = p.ID + 1
+	if p.X < 100 || p.X > 109 {
+		c.t.Errorf("X %d was not generated by generateX", p.X)
+	}
+	if p.Y > 0 || p.Y < -9 {
+		c.t.Errorf("Y %d was not generated by its expression", p.Y)
+	}
+	if !strings.HasPrefix(p.Label, "L") || len(p.Label) > 1+5 || strings.Trim(p.Label[1:], "x") != "" {
+		c.t.Errorf("label %q was not generated by generateLabel", p.Label)
+	}
+	if p.Z != 0 {
+		*c.others = true
+	}
+}
+
+func (c checkedCanvas) Draw(p Point) int {
+	c.check(p)
+	return c.canvas.Draw(p)
+}
+
+func (c checkedCanvas) Move(p *Point) int {
+	if p != nil {
+		c.check(*p)
+	}
+	return c.canvas.Move(p)
+}
+
+func TestFuzzFields(t *testing.T) {
+	nextID, others := ID(1), false
+	makeTest := func() Canvas { return checkedCanvas{t: t, nextID: &nextID, others: &others} }
+	if err := FuzzCanvas(makeTest, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+	if !others {
+		t.Error("expected the fields without generators to be generated by default")
+	}
+}
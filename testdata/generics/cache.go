@@ -0,0 +1,54 @@
+package generics
+
+/*
+@fuzz interface: Cache
+@known correct: newMapCache
+@instantiate: Cache[string, int]
+@instantiate: Cache[int, []byte]
+*/
+type Cache[K comparable, V any] interface {
+	Put(key K, value V)
+	Get(key K) (V, bool)
+	Len() int
+}
+
+type mapCache[K comparable, V any] struct {
+	values map[K]V
+}
+
+func newMapCache[K comparable, V any]() Cache[K, V] {
+	return &mapCache[K, V]{values: make(map[K]V)}
+}
+
+func (c *mapCache[K, V]) Put(key K, value V) { c.values[key] = value }
+
+func (c *mapCache[K, V]) Get(key K) (V, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+func (c *mapCache[K, V]) Len() int { return len(c.values) }
+
+// lossyCache only keeps the last value put.
+type lossyCache[K comparable, V any] struct {
+	key   K
+	value V
+	full  bool
+}
+
+func (c *lossyCache[K, V]) Put(key K, value V) { c.key, c.value, c.full = key, value, true }
+
+func (c *lossyCache[K, V]) Get(key K) (V, bool) {
+	if c.full && c.key == key {
+		return c.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *lossyCache[K, V]) Len() int {
+	if c.full {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,22 @@
+package generics
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFuzzInstantiations(t *testing.T) {
+	if err := FuzzCacheStringInt(newMapCache[string, int], rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := FuzzCacheIntByte(newMapCache[int, []byte], rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFuzzInstantiationsBroken(t *testing.T) {
+	makeTest := func() Cache[string, int] { return &lossyCache[string, int]{} }
+	if err := FuzzCacheStringInt(makeTest, rand.New(rand.NewSource(0)), 100); err == nil {
+		t.Fatal("expected a failure")
+	}
+}
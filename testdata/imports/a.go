@@ -0,0 +1,8 @@
+package imports
+
+import (
+	"fmt"
+	"strings"
+)
+
+var _ = fmt.Sprint(strings.ToUpper("a"))
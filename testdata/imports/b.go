@@ -0,0 +1,8 @@
+package imports
+
+import (
+	"fmt"
+	str "strings"
+)
+
+var _ = fmt.Sprint(str.ToLower("B"))
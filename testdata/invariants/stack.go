@@ -0,0 +1,43 @@
+package invariants
+
+/*
+@fuzz interface: Stack
+@invariant: %var.Len() >= 0
+*/
+type Stack interface {
+	Push(value int)
+	Pop() int
+	Len() int
+}
+
+type stack struct {
+	values []int
+}
+
+func (s *stack) Push(value int) { s.values = append(s.values, value) }
+
+func (s *stack) Pop() int {
+	if len(s.values) == 0 {
+		return 0
+	}
+	value := s.values[len(s.values)-1]
+	s.values = s.values[:len(s.values)-1]
+	return value
+}
+
+func (s *stack) Len() int { return len(s.values) }
+
+// brokenStack counts its values, and counts down even when it is
+// empty.
+type brokenStack struct {
+	count int
+}
+
+func (s *brokenStack) Push(value int) { s.count++ }
+
+func (s *brokenStack) Pop() int {
+	s.count--
+	return 0
+}
+
+func (s *brokenStack) Len() int { return s.count }
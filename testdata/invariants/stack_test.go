@@ -0,0 +1,31 @@
+package invariants
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestFuzzInvariants(t *testing.T) {
+	if err := FuzzStackInvariants(&stack{}, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFuzzInvariantsBroken(t *testing.T) {
+	err := FuzzStackInvariants(&brokenStack{}, rand.New(rand.NewSource(0)), 100)
+
+	var failure *StackFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if failure.Reason != "invariant violated: %var.Len() >= 0" {
+		t.Errorf("wrong reason %q", failure.Reason)
+	}
+	if failure.Method != "Pop" {
+		t.Errorf("expected Pop to fail, got %s", failure.Method)
+	}
+	if len(failure.Trace) == 0 || failure.Index != len(failure.Trace)-1 || failure.Trace[failure.Index].Method != "Pop" {
+		t.Errorf("expected the last operation, Pop, to fail, got %d of %v", failure.Index, failure.Trace)
+	}
+}
@@ -0,0 +1,37 @@
+package native
+
+/*
+@fuzz interface: Adder
+@known correct: newAdder
+*/
+type Adder interface {
+	Add(a, b, c, d int) int
+}
+
+type adder struct{}
+
+func newAdder() Adder { return adder{} }
+
+func (adder) Add(a, b, c, d int) int { return a + b + c + d }
+
+// countingAdder counts the calls to Add.
+type countingAdder struct {
+	adder
+	calls *int
+}
+
+func (c countingAdder) Add(a, b, c2, d int) int {
+	*c.calls++
+	return c.adder.Add(a, b, c2, d)
+}
+
+// brokenAdder gets the sum wrong when the arguments are in decreasing
+// order.
+type brokenAdder struct{ adder }
+
+func (brokenAdder) Add(a, b, c, d int) int {
+	if a > b && b > c && c > d {
+		return 0
+	}
+	return a + b + c + d
+}
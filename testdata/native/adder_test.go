@@ -0,0 +1,57 @@
+package native
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func FuzzAdderImpl(f *testing.F) {
+	FuzzAdderNative(f, newAdder)
+}
+
+// Count the operations performed on the input of a native fuzz test.
+func operations(t *testing.T, data []byte, maxops uint) (int, bool) {
+	calls := 0
+	makeTest := func() Adder { return countingAdder{calls: &calls} }
+
+	source := &fuzzByteSource{data: data}
+	if err := fuzzAdder(makeTest, rand.New(source), maxops, source.Exhausted); err != nil {
+		t.Fatal(err)
+	}
+	return calls, source.Exhausted()
+}
+
+func TestStopWhenExhausted(t *testing.T) {
+	// Each operation takes more than one value, so this runs out
+	// before 10 operations.
+	calls, exhausted := operations(t, make([]byte, 80), 10)
+	if !exhausted || calls >= 10 {
+		t.Errorf("expected the run to stop once the input is used up, got %d operations", calls)
+	}
+}
+
+func TestSeedIsLongEnough(t *testing.T) {
+	calls, exhausted := operations(t, fuzzNativeSeed(), 100)
+	if exhausted || calls != 100 {
+		t.Errorf("expected the seed to last for 100 operations, got %d", calls)
+	}
+}
+
+func TestSeedMatchesFuzzTest(t *testing.T) {
+	makeTest := func() Adder { return brokenAdder{} }
+
+	expected := FuzzAdder(makeTest, rand.New(rand.NewSource(0)), 100)
+	if expected == nil {
+		t.Fatal("expected the broken implementation to fail")
+	}
+
+	source := &fuzzByteSource{data: fuzzNativeSeed()}
+	actual := fuzzAdder(makeTest, rand.New(source), 100, source.Exhausted)
+	if actual == nil || actual.Error() != expected.Error() {
+		t.Errorf("expected the seed to perform the same operations as FuzzTestAdder\nexpected: %v\nactual:   %v", expected, actual)
+	}
+}
+
+func FuzzBrokenAdder(f *testing.F) {
+	FuzzAdderNative(f, func() Adder { return brokenAdder{} })
+}
@@ -0,0 +1,31 @@
+package patterns
+
+type Key string
+
+type Tree string
+
+type Query string
+
+/*
+@fuzz interface: Search
+@known correct: newSearch
+@size: 32
+@generator regex: Key "[a-c]{1,3}(-[0-9]+)*"
+@generator grammar: Tree q = q q q | "a"
+@generator grammar: Query query = pair | pair "&" query
+@generator grammar: Query pair  = key "=" `[a-z0-9]*`
+@generator grammar: Query key   = "page" | "limit"
+*/
+type Search interface {
+	Lookup(key Key) int
+	Match(tree Tree) int
+	Find(query Query) int
+}
+
+type search struct{}
+
+func newSearch() Search { return search{} }
+
+func (search) Lookup(key Key) int   { return len(key) }
+func (search) Match(tree Tree) int  { return len(tree) }
+func (search) Find(query Query) int { return len(query) }
@@ -0,0 +1,62 @@
+package patterns
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var (
+	keyPattern   = regexp.MustCompile(`^[a-c]{1,3}(-[0-9]+)*$`)
+	queryPattern = regexp.MustCompile(`^(page|limit)=[a-z0-9]*(&(page|limit)=[a-z0-9]*)*$`)
+)
+
+// checkedSearch checks that the values it is given were generated
+// from their patterns, and are not too long.
+type checkedSearch struct {
+	search
+	t *testing.T
+}
+
+func (s checkedSearch) Lookup(key Key) int {
+	if !keyPattern.MatchString(string(key)) {
+		s.t.Errorf("key %q does not match its regular expression", key)
+	}
+	return s.search.Lookup(key)
+}
+
+func (s checkedSearch) Match(tree Tree) int {
+	// Each of the at most 32 random picks adds two more rules.
+	if len(tree) == 0 || len(tree) > 1+2*32 || strings.Trim(string(tree), "a") != "" {
+		s.t.Errorf("tree %q was not derived from its grammar, or is too long", tree)
+	}
+	return s.search.Match(tree)
+}
+
+func (s checkedSearch) Find(query Query) int {
+	if !queryPattern.MatchString(string(query)) {
+		s.t.Errorf("query %q was not derived from its grammar", query)
+	}
+	return s.search.Find(query)
+}
+
+func TestFuzzPatterns(t *testing.T) {
+	makeTest := func() Search { return checkedSearch{t: t} }
+	if err := FuzzSearch(makeTest, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A surrogate picked from a negated class would be converted to the
+// replacement character, so that is left out of the class.
+func TestFuzzRegexpSurrogates(t *testing.T) {
+	rand := rand.New(rand.NewSource(0))
+	re := fuzzParseRegexp(`[^a\x{FFFD}]`)
+	for i := 0; i < 100000; i++ {
+		if s := fuzzRegexp(rand, 32, re); strings.ContainsRune(s, utf8.RuneError) {
+			t.Fatalf("%q is not a valid rune of the negated class", s)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package pools
+
+type Handle uint64
+
+/*
+@fuzz interface: Handles
+@known correct: newHandles
+@reuse: Handle 0.5
+*/
+type Handles interface {
+	Open() Handle
+	OpenBatch() [2]Handle
+	Close(h Handle) bool
+}
+
+// handles gives out handles in order, single handles counting up from
+// 100 and batches from 1000, so generated handles are almost never
+// open.
+type handles struct {
+	next  Handle
+	batch Handle
+	open  map[Handle]bool
+}
+
+func newHandles() Handles {
+	return &handles{next: 100, batch: 1000, open: map[Handle]bool{}}
+}
+
+func (hs *handles) Open() Handle {
+	h := hs.next
+	hs.next++
+	hs.open[h] = true
+	return h
+}
+
+func (hs *handles) OpenBatch() [2]Handle {
+	batch := [2]Handle{hs.batch, hs.batch + 1}
+	hs.batch += 2
+	hs.open[batch[0]], hs.open[batch[1]] = true, true
+	return batch
+}
+
+func (hs *handles) Close(h Handle) bool {
+	if !hs.open[h] {
+		return false
+	}
+	delete(hs.open, h)
+	return true
+}
+
+// leakyHandles never closes a handle from a batch.
+type leakyHandles struct{ *handles }
+
+func (hs leakyHandles) Close(h Handle) bool {
+	if h >= 1000 && h < 1<<32 {
+		return false
+	}
+	return hs.handles.Close(h)
+}
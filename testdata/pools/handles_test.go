@@ -0,0 +1,51 @@
+package pools
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// countingHandles counts the handles it closes.
+type countingHandles struct {
+	*handles
+	closed *int
+}
+
+func (hs countingHandles) Close(h Handle) bool {
+	ok := hs.handles.Close(h)
+	if ok {
+		*hs.closed++
+	}
+	return ok
+}
+
+func TestFuzzPools(t *testing.T) {
+	closed := 0
+	makeTest := func() Handles {
+		return countingHandles{handles: newHandles().(*handles), closed: &closed}
+	}
+
+	if err := FuzzHandles(makeTest, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+	if closed == 0 {
+		t.Fatal("expected open handles to be drawn from the pool and closed")
+	}
+}
+
+func TestFuzzBatches(t *testing.T) {
+	makeTest := func() Handles {
+		return leakyHandles{newHandles().(*handles)}
+	}
+
+	err := FuzzHandles(makeTest, rand.New(rand.NewSource(0)), 100)
+
+	var failure *HandlesFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if failure.Method != "Close" || failure.Args[0].(Handle) < 1000 {
+		t.Fatalf("expected a handle from a batch to be drawn from the pool, got %v", err)
+	}
+}
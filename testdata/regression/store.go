@@ -0,0 +1,45 @@
+package regression
+
+/*
+@fuzz interface: Store
+@known correct: newStore
+@invariant: %var.Len() >= 0
+*/
+type Store interface {
+	Put(key string, value Value)
+	Len() int
+	Score(key string) float64
+}
+
+// Value is rendered as a nested literal in regression tests.
+type Value struct {
+	Name  string
+	Score float64
+	Tags  map[string]int
+	Inner Inner
+}
+
+type Inner struct {
+	Flag bool
+	Data []byte
+}
+
+type store map[string]Value
+
+func newStore() Store { return make(store) }
+
+func (s store) Put(key string, value Value) { s[key] = value }
+func (s store) Len() int                    { return len(s) }
+func (s store) Score(key string) float64    { return s[key].Score }
+
+// brokenStore miscounts once it has more than two values.
+type brokenStore struct{ store }
+
+func newBrokenStore() Store { return brokenStore{make(store)} }
+
+func (s brokenStore) Len() int {
+	if len(s.store) > 2 {
+		return len(s.store) + 1
+	}
+	return len(s.store)
+}
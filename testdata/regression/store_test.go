@@ -0,0 +1,41 @@
+package regression
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFuzzBroken(t *testing.T) {
+	FuzzTestStore(newBrokenStore, t)
+}
+
+func TestFuzzLiteral(t *testing.T) {
+	pkg := reflect.TypeOf(Value{}).PkgPath()
+	for _, c := range []struct {
+		value   interface{}
+		literal string
+	}{
+		{"a\"b\n", `"a\"b\n"`},
+		{math.NaN(), "float64(math.NaN())"},
+		{float32(math.Inf(-1)), "float32(math.Inf(-1))"},
+		{math.Copysign(0, -1), "float64(math.Copysign(0, -1))"},
+		{map[string]int{"b": 2, "a": 1}, `map[string]int{"a": 1, "b": 2}`},
+		{
+			Value{Name: "x", Score: math.Inf(1), Tags: map[string]int{"b": 2}, Inner: Inner{Flag: true, Data: []byte{1}}},
+			`Value{Name: "x", Score: float64(math.Inf(1)), Tags: map[string]int{"b": 2}, Inner: Inner{Flag: true, Data: []uint8{uint8(1)}}}`,
+		},
+		{&Inner{}, "&Inner{Flag: false, Data: ([]uint8)(nil)}"},
+	} {
+		imports := make(map[string]bool)
+		literal, ok := fuzzLiteral(reflect.ValueOf(c.value), pkg, imports)
+		if !ok || literal != c.literal {
+			t.Errorf("rendered %#v as %s, expected %s", c.value, literal, c.literal)
+		}
+	}
+
+	// Callbacks can't be written as literals.
+	if _, ok := fuzzLiteral(reflect.ValueOf(func(int) bool { return true }), pkg, nil); ok {
+		t.Error("rendered a callback as a literal")
+	}
+}
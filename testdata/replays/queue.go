@@ -0,0 +1,67 @@
+package replays
+
+import "math/rand"
+
+type Values []int
+
+/*
+@fuzz interface: Queue
+@known correct: newQueue Values
+@generator: generateValues Values
+*/
+type Queue interface {
+	Pop() int
+	Len() int
+}
+
+// generateValues generates five values, none of them zero.
+func generateValues(rand *rand.Rand) Values {
+	values := make(Values, 5)
+	for i := range values {
+		values[i] = 1 + rand.Intn(100)
+	}
+	return values
+}
+
+type queue struct {
+	values Values
+	popped int
+}
+
+func newQueue(values Values) Queue { return &queue{values: values} }
+
+func (q *queue) Pop() int {
+	if len(q.values) == 0 {
+		return 0
+	}
+	value := q.values[0]
+	q.values = q.values[1:]
+	q.popped++
+	return value
+}
+
+func (q *queue) Len() int { return len(q.values) }
+
+// brokenQueue zeroes the values it pops, in the slice it was made
+// with, and gets its length wrong after three pops.
+type brokenQueue struct{ queue }
+
+func newBrokenQueue(values Values) Queue { return &brokenQueue{queue{values: values}} }
+
+func (q *brokenQueue) Pop() int {
+	if len(q.values) == 0 {
+		return 0
+	}
+	value := q.values[0]
+	q.values[0] = 0
+	q.values = q.values[1:]
+	q.popped++
+	return value
+}
+
+func (q *brokenQueue) Len() int {
+	if q.popped >= 3 {
+		return len(q.values) + 1
+	}
+	return len(q.values)
+}
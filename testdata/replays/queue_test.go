@@ -0,0 +1,31 @@
+package replays
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// If the arguments to makeTest were shared with the reference
+// implementation, or between replays, the values zeroed by the broken
+// implementation would make Pop fail in the replays instead.
+func TestFuzzReplays(t *testing.T) {
+	err := FuzzQueue(newBrokenQueue, rand.New(rand.NewSource(0)), 100)
+
+	var failure *QueueFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	if failure.Method != "Len" || len(failure.Trace) != 4 {
+		t.Fatalf("expected Len to fail after three pops, got %v", failure)
+	}
+	for _, value := range failure.MakeArgs[0].(Values) {
+		if value == 0 {
+			t.Errorf("the arguments to makeTest %v were changed", failure.MakeArgs[0])
+		}
+	}
+}
+
+func TestFuzzBroken(t *testing.T) {
+	FuzzTestQueue(newBrokenQueue, t)
+}
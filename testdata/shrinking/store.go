@@ -0,0 +1,43 @@
+package shrinking
+
+import "math/rand"
+
+type Key string
+
+type ID int
+
+/*
+@fuzz interface: Store
+@known correct: newStore
+@generator regex: Key "[a-c]{1,3}"
+@generator Page.limit: expr 1 + %rand.Intn(%size)
+@generator: generateID ID
+*/
+type Store interface {
+	Get(key Key) int
+	Page(offset int, limit int) int
+	Lookup(id ID) int
+}
+
+// IDs are always between 100 and 199.
+func generateID(rand *rand.Rand) ID {
+	return ID(100 + rand.Intn(100))
+}
+
+type store struct{}
+
+func newStore() Store { return store{} }
+
+func (store) Get(key Key) int                { return len(key) }
+func (store) Page(offset int, limit int) int { return offset + limit }
+func (store) Lookup(id ID) int               { return int(id) }
+
+// Each broken implementation gets one method wrong, for every
+// argument.
+type brokenGet struct{ store }
+type brokenPage struct{ store }
+type brokenLookup struct{ store }
+
+func (brokenGet) Get(key Key) int                 { return -1 }
+func (brokenPage) Page(offset int, limit int) int { return -1 }
+func (brokenLookup) Lookup(id ID) int             { return -1 }
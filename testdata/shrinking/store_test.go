@@ -0,0 +1,43 @@
+package shrinking
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+// Find the shrunk failure of a broken implementation.
+func failure(t *testing.T, makeTest func() Store) *StoreFuzzFailure {
+	err := FuzzStore(makeTest, rand.New(rand.NewSource(0)), 100)
+
+	var failure *StoreFuzzFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected a failure, got %v", err)
+	}
+	return failure
+}
+
+func TestShrinkRegex(t *testing.T) {
+	f := failure(t, func() Store { return brokenGet{} })
+	if key := f.Args[0].(Key); !regexp.MustCompile(`^[a-c]{1,3}$`).MatchString(string(key)) {
+		t.Errorf("shrunk key %q does not match its regular expression", key)
+	}
+}
+
+func TestShrinkParam(t *testing.T) {
+	f := failure(t, func() Store { return brokenPage{} })
+	if offset := f.Args[0].(int); offset != 0 {
+		t.Errorf("default generated offset %d was not shrunk", offset)
+	}
+	if limit := f.Args[1].(int); limit < 1 {
+		t.Errorf("shrunk limit %d was never generated", limit)
+	}
+}
+
+func TestShrinkGenerator(t *testing.T) {
+	f := failure(t, func() Store { return brokenLookup{} })
+	if id := f.Args[0].(ID); id < 100 || id > 199 {
+		t.Errorf("shrunk ID %d was never generated", id)
+	}
+}
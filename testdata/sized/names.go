@@ -0,0 +1,32 @@
+package sized
+
+import (
+	"math/rand"
+	"strings"
+)
+
+type Name string
+
+/*
+@fuzz interface: Names
+@known correct: newNames
+@size: 16
+@generator: ~ generateName Name
+@generator Put.count: expr %size
+*/
+type Names interface {
+	Put(name Name, count int) int
+	Tags(tags []string) int
+}
+
+// Names are as long as the size.
+func generateName(rand *rand.Rand, size int) Name {
+	return Name(strings.Repeat("n", size))
+}
+
+type names struct{}
+
+func newNames() Names { return names{} }
+
+func (names) Put(name Name, count int) int { return len(name) + count }
+func (names) Tags(tags []string) int       { return len(tags) }
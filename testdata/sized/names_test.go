@@ -0,0 +1,47 @@
+package sized
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// checkedNames checks the sizes of the values it is given.
+type checkedNames struct {
+	names
+	t    *testing.T
+	size *int
+}
+
+func (n checkedNames) Put(name Name, count int) int {
+	if len(name) != count {
+		n.t.Errorf("name %q and count %d were generated with different sizes", name, count)
+	}
+	if count < *n.size || count > 16 {
+		n.t.Errorf("size %d is smaller than an earlier size %d, or larger than the maximum", count, *n.size)
+	}
+	*n.size = count
+	return n.names.Put(name, count)
+}
+
+func (n checkedNames) Tags(tags []string) int {
+	if len(tags) > 16 {
+		n.t.Errorf("%d tags are more than the maximum size", len(tags))
+	}
+	for _, tag := range tags {
+		if len(tag) > 16 {
+			n.t.Errorf("tag %q is longer than the maximum size", tag)
+		}
+	}
+	return n.names.Tags(tags)
+}
+
+func TestFuzzSizes(t *testing.T) {
+	size := 0
+	makeTest := func() Names { return checkedNames{t: t, size: &size} }
+	if err := FuzzNames(makeTest, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+	if size < 15 {
+		t.Fatalf("expected the size to grow to the maximum over the run, got %d", size)
+	}
+}
@@ -0,0 +1,15 @@
+package undefined
+
+/*
+@fuzz interface: Store
+@known correct: newStore
+*/
+type Store interface {
+	Get(key string) int
+}
+
+type store map[string]int
+
+func newStore() store { return make(store) }
+
+func (s store) Get(key string) int { return s[key] }
@@ -0,0 +1,4 @@
+package undefined
+
+// The fuzzer for Store has not been generated yet.
+var _ = FuzzStoreWith
@@ -0,0 +1,31 @@
+package variadic
+
+/*
+@fuzz interface: Log
+@known correct: newLog
+@size: 3
+@variadic: 1 10
+*/
+type Log interface {
+	Append(tag string, lines ...string) int
+	Sum(values ...int) int
+}
+
+type log struct {
+	lines []string
+}
+
+func newLog() Log { return &log{} }
+
+func (l *log) Append(tag string, lines ...string) int {
+	l.lines = append(l.lines, lines...)
+	return len(l.lines)
+}
+
+func (l *log) Sum(values ...int) int {
+	sum := 0
+	for _, value := range values {
+		sum += value
+	}
+	return sum
+}
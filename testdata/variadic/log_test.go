@@ -0,0 +1,45 @@
+package variadic
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// checkedLog checks the number of variadic arguments it is given.
+type checkedLog struct {
+	Log
+	t       *testing.T
+	longest *int
+}
+
+func (l checkedLog) check(n int) {
+	// The size is at most 3, so at most 3 more than the minimum of
+	// 1 are generated.
+	if n < 1 || n > 4 {
+		l.t.Errorf("%d variadic arguments are outside the range, or more than the size allows", n)
+	}
+	if n > *l.longest {
+		*l.longest = n
+	}
+}
+
+func (l checkedLog) Append(tag string, lines ...string) int {
+	l.check(len(lines))
+	return l.Log.Append(tag, lines...)
+}
+
+func (l checkedLog) Sum(values ...int) int {
+	l.check(len(values))
+	return l.Log.Sum(values...)
+}
+
+func TestFuzzVariadic(t *testing.T) {
+	longest := 0
+	makeTest := func() Log { return checkedLog{Log: newLog(), t: t, longest: &longest} }
+	if err := FuzzLog(makeTest, rand.New(rand.NewSource(0)), 100); err != nil {
+		t.Fatal(err)
+	}
+	if longest != 4 {
+		t.Errorf("expected up to 4 variadic arguments, got %d", longest)
+	}
+}
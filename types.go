@@ -0,0 +1,661 @@
+// Resolve types with go/types.
+//
+// Types are parsed from the source syntax and from special comments,
+// so the same type may be written in several different ways. To look
+// up generators and comparisons by the type they actually denote,
+// Types are resolved in the scope of the package being fuzzed.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TypeEnv resolves Types in the scope of a type-checked package.
+type TypeEnv struct {
+	// The type-checked package.
+	pkg *types.Package
+
+	// Map from the names packages are imported under to the
+	// packages themselves.
+	imports map[string]*types.Package
+
+	// Already-resolved types, keyed by ToString'd Type.
+	cache map[string]types.Type
+}
+
+// NewTypeEnv creates a type environment for a package. The files are
+// used to find the names packages are imported under. If the package
+// was not type checked, nil is returned.
+func NewTypeEnv(pkg *types.Package, files []*ast.File) *TypeEnv {
+	if pkg == nil {
+		return nil
+	}
+
+	env := TypeEnv{
+		pkg:     pkg,
+		imports: make(map[string]*types.Package),
+		cache:   make(map[string]types.Type),
+	}
+
+	byPath := make(map[string]*types.Package)
+	for _, ipkg := range pkg.Imports() {
+		byPath[ipkg.Path()] = ipkg
+		env.imports[ipkg.Name()] = ipkg
+	}
+
+	for _, file := range files {
+		for _, iport := range file.Imports {
+			if iport.Name == nil {
+				continue
+			}
+			path, err := strconv.Unquote(iport.Path.Value)
+			if err != nil {
+				continue
+			}
+			if ipkg, ok := byPath[path]; ok {
+				env.imports[iport.Name.Name] = ipkg
+			}
+		}
+	}
+
+	return &env
+}
+
+// Resolve finds the go/types type denoted by a Type.
+func (env *TypeEnv) Resolve(ty Type) (types.Type, error) {
+	if env == nil {
+		return nil, fmt.Errorf("no type information for %s", ty.ToString())
+	}
+
+	tyname := ty.ToString()
+	if resolved, ok := env.cache[tyname]; ok {
+		return resolved, nil
+	}
+
+	resolved, err := env.resolve(ty)
+	if err != nil {
+		return nil, err
+	}
+
+	env.cache[tyname] = resolved
+	return resolved, nil
+}
+
+func (env *TypeEnv) resolve(ty Type) (types.Type, error) {
+	switch x := ty.(type) {
+	case *BasicType:
+		_, obj := env.pkg.Scope().LookupParent(string(*x), 0)
+		return lookupTypeName(obj, ty)
+	case *QualifiedType:
+		name, ok := x.Type.(*BasicType)
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve %s", ty.ToString())
+		}
+		if ipkg, ok := env.imports[x.Package]; ok {
+			return lookupTypeName(ipkg.Scope().Lookup(string(*name)), ty)
+		}
+		if x.Package == env.pkg.Name() {
+			return lookupTypeName(env.pkg.Scope().Lookup(string(*name)), ty)
+		}
+		return nil, fmt.Errorf("unknown package '%s'", x.Package)
+	case *ArrayType:
+		elem, err := env.resolve(x.ElementType)
+		if err != nil {
+			return nil, err
+		}
+		if x.Length == "" {
+			return types.NewSlice(elem), nil
+		}
+		length, err := env.resolveLength(x.Length)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewArray(elem, length), nil
+	case *ChanType:
+		elem, err := env.resolve(x.ElementType)
+		if err != nil {
+			return nil, err
+		}
+		dir := types.SendRecv
+		switch x.Dir {
+		case ChanSend:
+			dir = types.SendOnly
+		case ChanRecv:
+			dir = types.RecvOnly
+		}
+		return types.NewChan(dir, elem), nil
+	case *FuncType:
+		params, err := env.resolveTuple(x.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		results, err := env.resolveTuple(x.Returns)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewSignatureType(nil, nil, nil, params, results, x.Variadic), nil
+	case *InstanceType:
+		generic, err := env.resolve(x.Type)
+		if err != nil {
+			return nil, err
+		}
+		var args []types.Type
+		for _, arg := range x.TypeArgs {
+			resolved, err := env.resolve(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, resolved)
+		}
+		return types.Instantiate(nil, generic, args, true)
+	case *InterfaceType:
+		var methods []*types.Func
+		for _, function := range x.Methods {
+			sig, err := env.resolve(&FuncType{Parameters: function.Parameters, Returns: function.Returns, Variadic: function.Variadic})
+			if err != nil {
+				return nil, err
+			}
+			methods = append(methods, types.NewFunc(0, env.pkg, function.Name, sig.(*types.Signature)))
+		}
+		var embeddeds []types.Type
+		for _, embedded := range x.Embedded {
+			resolved, err := env.resolve(embedded)
+			if err != nil {
+				return nil, err
+			}
+			embeddeds = append(embeddeds, resolved)
+		}
+		return types.NewInterfaceType(methods, embeddeds).Complete(), nil
+	case *MapType:
+		key, err := env.resolve(x.KeyType)
+		if err != nil {
+			return nil, err
+		}
+		value, err := env.resolve(x.ValueType)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewMap(key, value), nil
+	case *PointerType:
+		target, err := env.resolve(x.TargetType)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewPointer(target), nil
+	case *StructType:
+		var fields []*types.Var
+		var tags []string
+		for _, field := range x.Fields {
+			resolved, err := env.resolve(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			tag := ""
+			if field.Tag != "" {
+				tag, err = strconv.Unquote(field.Tag)
+				if err != nil {
+					return nil, fmt.Errorf("invalid tag %s on field '%s'", field.Tag, field.Name)
+				}
+			}
+			fields = append(fields, types.NewField(0, env.pkg, field.Name, resolved, field.Embedded))
+			tags = append(tags, tag)
+		}
+		return types.NewStruct(fields, tags), nil
+	}
+
+	return nil, fmt.Errorf("cannot resolve %s", ty.ToString())
+}
+
+// Evaluate the length of an array type, which may be any constant
+// expression in the scope of the package.
+func (env *TypeEnv) resolveLength(length string) (int64, error) {
+	tv, err := types.Eval(token.NewFileSet(), env.pkg, token.NoPos, length)
+	if err != nil {
+		return 0, err
+	}
+	if tv.Value == nil || tv.Value.Kind() != constant.Int {
+		return 0, fmt.Errorf("array length '%s' is not an integer constant", length)
+	}
+
+	n, ok := constant.Int64Val(tv.Value)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("invalid array length '%s'", length)
+	}
+	return n, nil
+}
+
+// Resolve a list of types into a tuple of unnamed variables.
+func (env *TypeEnv) resolveTuple(tys []Type) (*types.Tuple, error) {
+	var vars []*types.Var
+	for _, ty := range tys {
+		resolved, err := env.resolve(ty)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, types.NewParam(0, env.pkg, "", resolved))
+	}
+
+	return types.NewTuple(vars...), nil
+}
+
+// Get the type from an object which should be a type name.
+func lookupTypeName(obj types.Object, ty Type) (types.Type, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("unknown type '%s'", ty.ToString())
+	}
+
+	tyname, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a type", ty.ToString())
+	}
+
+	return tyname.Type(), nil
+}
+
+// Identical checks if two Types denote the same type. If either
+// cannot be resolved, they are compared by their string rendition.
+func (env *TypeEnv) Identical(ty1, ty2 Type) bool {
+	if ty1.ToString() == ty2.ToString() {
+		return true
+	}
+
+	resolved1, err1 := env.Resolve(ty1)
+	resolved2, err2 := env.Resolve(ty2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	return types.Identical(resolved1, resolved2)
+}
+
+// Underlying gets the name of the basic type underlying a Type, if
+// there is one.
+func (env *TypeEnv) Underlying(ty Type) (string, bool) {
+	resolved, err := env.Resolve(ty)
+	if err != nil {
+		return "", false
+	}
+
+	basic, ok := resolved.Underlying().(*types.Basic)
+	if !ok || basic.Kind() == types.UntypedNil || basic.Kind() == types.Invalid {
+		return "", false
+	}
+
+	return basic.Name(), true
+}
+
+// UnderlyingType gets the underlying type of a named type, if it is
+// not the type itself.
+func (env *TypeEnv) UnderlyingType(ty Type) (Type, bool) {
+	if env == nil {
+		return nil, false
+	}
+
+	resolved, err := env.Resolve(ty)
+	if err != nil {
+		return nil, false
+	}
+
+	if _, ok := types.Unalias(resolved).(*types.Named); !ok {
+		return nil, false
+	}
+	under, err := env.TypeFromTypesType(resolved.Underlying())
+	if err != nil {
+		return nil, false
+	}
+	return under, true
+}
+
+// SharesMemory checks if copying a value of a type may not copy all of
+// it: if it is, or contains, a slice, map, pointer, or interface.
+// Channels and functions can't be copied, so don't count. A type which
+// can't be resolved is assumed to share memory.
+func (env *TypeEnv) SharesMemory(ty Type) bool {
+	resolved, err := env.Resolve(ty)
+	if err != nil {
+		return true
+	}
+	return sharesMemory(resolved)
+}
+
+func sharesMemory(ty types.Type) bool {
+	switch ty := ty.Underlying().(type) {
+	case *types.Basic, *types.Chan, *types.Signature:
+		return false
+	case *types.Array:
+		return sharesMemory(ty.Elem())
+	case *types.Struct:
+		for i := 0; i < ty.NumFields(); i++ {
+			if sharesMemory(ty.Field(i).Type()) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// Comparable checks if values of a type can be compared with ==. A
+// type which can't be resolved is assumed not to be.
+func (env *TypeEnv) Comparable(ty Type) bool {
+	resolved, err := env.Resolve(ty)
+	if err != nil {
+		return false
+	}
+	return types.Comparable(resolved)
+}
+
+// MethodSet gets the methods of an interface type, including those of
+// any interfaces it embeds.
+func (env *TypeEnv) MethodSet(ty Type) ([]Function, error) {
+	resolved, err := env.Resolve(ty)
+	if err != nil {
+		return nil, err
+	}
+
+	iface, ok := resolved.Underlying().(*types.Interface)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an interface", ty.ToString())
+	}
+
+	var functions []Function
+	for i := 0; i < iface.NumMethods(); i++ {
+		method := iface.Method(i)
+		function, err := env.FunctionFromSignature(method.Name(), method.Type().(*types.Signature))
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, function)
+	}
+
+	return functions, nil
+}
+
+// FunctionFromSignature converts a go/types function signature into a
+// Function.
+func (env *TypeEnv) FunctionFromSignature(name string, sig *types.Signature) (Function, error) {
+	function := Function{Name: name, Variadic: sig.Variadic()}
+
+	for i := 0; i < sig.Params().Len(); i++ {
+		ty, err := env.TypeFromTypesType(sig.Params().At(i).Type())
+		if err != nil {
+			return function, err
+		}
+		function.Parameters = append(function.Parameters, ty)
+		function.ParameterNames = append(function.ParameterNames, sig.Params().At(i).Name())
+	}
+
+	for i := 0; i < sig.Results().Len(); i++ {
+		ty, err := env.TypeFromTypesType(sig.Results().At(i).Type())
+		if err != nil {
+			return function, err
+		}
+		function.Returns = append(function.Returns, ty)
+	}
+
+	return function, nil
+}
+
+// TypeFromTypesType converts a go/types type into a Type. Types from
+// other packages are qualified with the name the package is imported
+// under.
+func (env *TypeEnv) TypeFromTypesType(ty types.Type) (Type, error) {
+	switch x := ty.(type) {
+	case *types.Basic:
+		basicTy := BasicType(x.Name())
+		return &basicTy, nil
+	case *types.Named:
+		named := env.typeFromTypeName(x.Obj())
+		if x.TypeArgs().Len() == 0 {
+			return named, nil
+		}
+		ty := InstanceType{Type: named}
+		for i := 0; i < x.TypeArgs().Len(); i++ {
+			arg, err := env.TypeFromTypesType(x.TypeArgs().At(i))
+			if err != nil {
+				return nil, err
+			}
+			ty.TypeArgs = append(ty.TypeArgs, arg)
+		}
+		return &ty, nil
+	case *types.TypeParam:
+		basicTy := BasicType(x.Obj().Name())
+		return &basicTy, nil
+	case *types.Alias:
+		return env.typeFromTypeName(x.Obj()), nil
+	case *types.Signature:
+		function, err := env.FunctionFromSignature("", x)
+		if err != nil {
+			return nil, err
+		}
+		return &FuncType{Parameters: function.Parameters, Returns: function.Returns, Variadic: function.Variadic}, nil
+	case *types.Slice:
+		elem, err := env.TypeFromTypesType(x.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &ArrayType{ElementType: elem}, nil
+	case *types.Array:
+		elem, err := env.TypeFromTypesType(x.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &ArrayType{ElementType: elem, Length: strconv.FormatInt(x.Len(), 10)}, nil
+	case *types.Chan:
+		elem, err := env.TypeFromTypesType(x.Elem())
+		if err != nil {
+			return nil, err
+		}
+		ty := ChanType{ElementType: elem}
+		switch x.Dir() {
+		case types.SendOnly:
+			ty.Dir = ChanSend
+		case types.RecvOnly:
+			ty.Dir = ChanRecv
+		}
+		return &ty, nil
+	case *types.Interface:
+		ty := InterfaceType{}
+		for i := 0; i < x.NumExplicitMethods(); i++ {
+			method := x.ExplicitMethod(i)
+			function, err := env.FunctionFromSignature(method.Name(), method.Type().(*types.Signature))
+			if err != nil {
+				return nil, err
+			}
+			ty.Methods = append(ty.Methods, function)
+		}
+		for i := 0; i < x.NumEmbeddeds(); i++ {
+			embedded, err := env.TypeFromTypesType(x.EmbeddedType(i))
+			if err != nil {
+				return nil, err
+			}
+			ty.Embedded = append(ty.Embedded, embedded)
+		}
+		return &ty, nil
+	case *types.Struct:
+		ty := StructType{}
+		for i := 0; i < x.NumFields(); i++ {
+			field := x.Field(i)
+			fieldTy, err := env.TypeFromTypesType(field.Type())
+			if err != nil {
+				return nil, err
+			}
+			tag := ""
+			if x.Tag(i) != "" {
+				tag = strconv.Quote(x.Tag(i))
+			}
+			ty.Fields = append(ty.Fields, Field{Name: field.Name(), Type: fieldTy, Embedded: field.Embedded(), Tag: tag})
+		}
+		return &ty, nil
+	case *types.Map:
+		key, err := env.TypeFromTypesType(x.Key())
+		if err != nil {
+			return nil, err
+		}
+		value, err := env.TypeFromTypesType(x.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &MapType{KeyType: key, ValueType: value}, nil
+	case *types.Pointer:
+		target, err := env.TypeFromTypesType(x.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &PointerType{TargetType: target}, nil
+	}
+
+	return nil, fmt.Errorf("cannot represent type %s", ty.String())
+}
+
+// Convert a type name into a Type, qualifying it if it is from
+// another package.
+func (env *TypeEnv) typeFromTypeName(obj *types.TypeName) Type {
+	basicTy := BasicType(obj.Name())
+	if obj.Pkg() == nil || obj.Pkg() == env.pkg {
+		return &basicTy
+	}
+
+	return &QualifiedType{Package: env.packageName(obj.Pkg()), Type: &basicTy}
+}
+
+// Get the name another package is imported under.
+func (env *TypeEnv) packageName(pkg *types.Package) string {
+	// Prefer the package's own name, unless it has been shadowed by
+	// another import.
+	pkgname := pkg.Name()
+	if ipkg, ok := env.imports[pkgname]; ok && ipkg.Path() != pkg.Path() {
+		var names []string
+		for name, ipkg := range env.imports {
+			if ipkg.Path() == pkg.Path() {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			pkgname = names[0]
+		}
+	}
+
+	return pkgname
+}
+
+// Constants gets the names of the constants declared with a named
+// type, in the order they are declared, skipping any which repeat an
+// earlier value. Constants declared in another package are qualified,
+// and only included if they are exported.
+func (env *TypeEnv) Constants(ty Type) []string {
+	if env == nil {
+		return nil
+	}
+
+	resolved, err := env.Resolve(ty)
+	if err != nil {
+		return nil
+	}
+	named, ok := types.Unalias(resolved).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil
+	}
+
+	cpkg := named.Obj().Pkg()
+	var consts []*types.Const
+	for _, name := range cpkg.Scope().Names() {
+		c, ok := cpkg.Scope().Lookup(name).(*types.Const)
+		if !ok || !types.Identical(c.Type(), named) {
+			continue
+		}
+		if cpkg != env.pkg && !c.Exported() {
+			continue
+		}
+		consts = append(consts, c)
+	}
+	sort.Slice(consts, func(i, j int) bool { return consts[i].Pos() < consts[j].Pos() })
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, c := range consts {
+		value := c.Val().ExactString()
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+
+		name := c.Name()
+		if cpkg != env.pkg {
+			name = env.packageName(cpkg) + "." + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// IsLocal checks if a Type is a named type declared in the package
+// itself.
+func (env *TypeEnv) IsLocal(ty Type) bool {
+	if env == nil {
+		return false
+	}
+
+	resolved, err := env.Resolve(ty)
+	if err != nil {
+		return false
+	}
+	named, ok := types.Unalias(resolved).(*types.Named)
+	return ok && named.Obj().Pkg() == env.pkg
+}
+
+// ImportSpec gives the import declaration for a package imported
+// under a name, for use in another file of the same package.
+func (env *TypeEnv) ImportSpec(name string) (string, bool) {
+	if env == nil {
+		return "", false
+	}
+
+	ipkg, ok := env.imports[name]
+	if !ok {
+		return "", false
+	}
+
+	spec := strconv.Quote(ipkg.Path())
+	if ipkg.Name() != name {
+		spec = name + " " + spec
+	}
+	return spec, true
+}
+
+// IsGenericFunc checks if a function name, which may be qualified with
+// a package name, refers to a generic function. Generic functions
+// must be explicitly instantiated when called.
+func (env *TypeEnv) IsGenericFunc(name string) bool {
+	if env == nil {
+		return false
+	}
+
+	scope := env.pkg.Scope()
+	if i := strings.Index(name, "."); i >= 0 {
+		ipkg, ok := env.imports[name[:i]]
+		if !ok {
+			return false
+		}
+		scope = ipkg.Scope()
+		name = name[i+1:]
+	}
+
+	fun, ok := scope.Lookup(name).(*types.Func)
+	if !ok {
+		return false
+	}
+
+	sig, ok := fun.Type().(*types.Signature)
+	return ok && sig.TypeParams().Len() > 0
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+const typesTestSource = `
+package example
+
+type ID uint64
+
+type Alias = ID
+
+type Other uint64
+
+const Size = 4
+
+type Message struct {
+	ID ID
+}
+`
+
+// Check that the same type written in different ways is identical.
+func TestTypeEnvIdentical(t *testing.T) {
+	env := typeCheck(typesTestSource, t)
+
+	id := BasicType("ID")
+	alias := BasicType("Alias")
+	qualified := QualifiedType{Package: "example", Type: &id}
+	other := BasicType("Other")
+
+	if !env.Identical(&id, &alias) {
+		t.Fatal("Type alias not identical to aliased type.")
+	}
+	if !env.Identical(&id, &qualified) {
+		t.Fatal("Qualified type not identical to unqualified type.")
+	}
+	if !env.Identical(&ArrayType{ElementType: &id}, &ArrayType{ElementType: &alias}) {
+		t.Fatal("Slices of identical types not identical.")
+	}
+	if env.Identical(&id, &other) {
+		t.Fatal("Distinct named types reported identical.")
+	}
+	if !env.Identical(&ArrayType{ElementType: &id, Length: "Size"}, &ArrayType{ElementType: &id, Length: "2 * 2"}) {
+		t.Fatal("Arrays with equal constant lengths not identical.")
+	}
+	if env.Identical(&ArrayType{ElementType: &id, Length: "Size"}, &ArrayType{ElementType: &id}) {
+		t.Fatal("Array reported identical to slice.")
+	}
+	if env.Identical(&ChanType{ElementType: &id, Dir: ChanSend}, &ChanType{ElementType: &id}) {
+		t.Fatal("Send-only channel reported identical to bidirectional channel.")
+	}
+}
+
+// Check that named types have the correct underlying basic type.
+func TestTypeEnvUnderlying(t *testing.T) {
+	env := typeCheck(typesTestSource, t)
+
+	id := BasicType("ID")
+	if basic, ok := env.Underlying(&id); !ok || basic != "uint64" {
+		expectedActual("Wrong underlying type.", "uint64", basic, t)
+	}
+
+	message := BasicType("Message")
+	if _, ok := env.Underlying(&message); ok {
+		t.Fatal("Struct type reported as having an underlying basic type.")
+	}
+}
+
+// Check that types which contain references are found to share
+// memory.
+func TestTypeEnvSharesMemory(t *testing.T) {
+	env := typeCheck(`
+package example
+
+type ID uint64
+
+type Message struct {
+	ID   ID
+	Sent [2]bool
+}
+
+type Batch struct {
+	Messages []Message
+}
+
+type Handler func(Message)
+`, t)
+
+	id := BasicType("ID")
+	message := BasicType("Message")
+	batch := BasicType("Batch")
+	handler := BasicType("Handler")
+	unknown := BasicType("Unknown")
+
+	for _, ty := range []Type{&id, &message, &ArrayType{ElementType: &message, Length: "3"}, &handler, &ChanType{ElementType: &batch}} {
+		if env.SharesMemory(ty) {
+			t.Fatalf("%s reported as sharing memory.", ty.ToString())
+		}
+	}
+	for _, ty := range []Type{&batch, &ArrayType{ElementType: &id}, &PointerType{TargetType: &message}, &MapType{KeyType: &id, ValueType: &id}, &ArrayType{ElementType: &batch, Length: "1"}, &unknown} {
+		if !env.SharesMemory(ty) {
+			t.Fatalf("%s not reported as sharing memory.", ty.ToString())
+		}
+	}
+}
+
+// Check that types which can be compared with == are found.
+func TestTypeEnvComparable(t *testing.T) {
+	id := BasicType("ID")
+	message := BasicType("Message")
+	unknown := BasicType("Unknown")
+
+	env := typeCheck(typesTestSource, t)
+	if !env.Comparable(&id) || !env.Comparable(&message) {
+		t.Fatal("Comparable type not reported as comparable.")
+	}
+	if env.Comparable(&ArrayType{ElementType: &id}) || env.Comparable(&unknown) {
+		t.Fatal("Type reported as comparable.")
+	}
+}
+
+// Check that the constants of a named type are found in declaration
+// order, without repeated values.
+func TestTypeEnvConstants(t *testing.T) {
+	env := typeCheck(`
+package example
+
+type Mode int
+
+const (
+	ModeB Mode = iota + 1
+	ModeA
+	ModeDefault = ModeA
+	modeHidden  Mode = 7
+	Other            = 8
+)
+`, t)
+
+	mode := BasicType("Mode")
+	expected := []string{"ModeB", "ModeA", "modeHidden"}
+	actual := env.Constants(&mode)
+	if len(actual) != len(expected) {
+		expectedActual("Wrong constants.", expected, actual, t)
+	}
+	for i, name := range expected {
+		if actual[i] != name {
+			expectedActual("Wrong constants.", expected, actual, t)
+		}
+	}
+
+	if !env.IsLocal(&mode) {
+		t.Fatal("Named type not reported as local.")
+	}
+}
+
+// Check that a nil environment falls back to string comparison.
+func TestTypeEnvNil(t *testing.T) {
+	var env *TypeEnv
+
+	id := BasicType("ID")
+	alias := BasicType("Alias")
+
+	if !env.Identical(&id, &id) {
+		t.Fatal("Type not identical to itself.")
+	}
+	if env.Identical(&id, &alias) {
+		t.Fatal("Types identical without type information.")
+	}
+}
+
+// Helper for type checking a single file.
+func typeCheck(src string, t *testing.T) *TypeEnv {
+	return typeCheckWith(types.Config{}, src, t)
+}
+
+// Helper for type checking a single file which imports packages from
+// the standard library.
+func typeCheckImporting(src string, t *testing.T) *TypeEnv {
+	return typeCheckWith(types.Config{Importer: importer.ForCompiler(token.NewFileSet(), "source", nil)}, src, t)
+}
+
+func typeCheckWith(conf types.Config, src string, t *testing.T) *TypeEnv {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "example.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := conf.Check("example", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewTypeEnv(pkg, []*ast.File{file})
+}
@@ -13,6 +13,11 @@ import (
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"regexp/syntax"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -22,7 +27,9 @@ type WantedFuzzer struct {
 	// The name of the interface.
 	InterfaceName string
 
-	// The function to produce a reference implementation.
+	// The function to produce a reference implementation. This
+	// has an empty name if there is no "@known correct" line, in
+	// which case only the invariants can be checked.
 	Reference Function
 
 	// If true, the reference function returns a value rather than a
@@ -30,27 +37,220 @@ type WantedFuzzer struct {
 	ReturnsValue bool
 
 	// Invariant expressions.
-	Invariants []string
+	Invariants []Invariant
 
 	// Comparison functions to use. The keys of this map are
 	// ToString'd Types.
 	Comparison map[string]EitherFunctionOrMethod
 
-	// Generator functions The keys of this map are ToString'd Types.
+	// Generator functions. The keys of this map are ToString'd Types.
 	Generator map[string]Generator
 
+	// Generator functions for fields of struct types. The keys of
+	// this map are ToString'd Types and field names, joined with a
+	// ".".
+	FieldGenerator map[string]FieldGenerator
+
+	// Generators for single parameters of methods, which take
+	// precedence over the generators for their types. The keys of
+	// this map are method and parameter names, joined with a ".".
+	ParamGenerator map[string]Generator
+
 	// Initial state for custom generator functions.
 	GeneratorState string
+
+	// Shrinker functions. The keys of this map are ToString'd
+	// Types.
+	Shrinker map[string]Shrinker
+
+	// Types of which returned values are kept, to be reused as
+	// later arguments. The keys of this map are ToString'd Types.
+	Reuse map[string]Reuse
+
+	// Types of which generated values are usually one of a fixed
+	// set. The keys of this map are ToString'd Types.
+	Enum map[string]Enum
+
+	// Type arguments to instantiate a generic interface with. Each
+	// instantiation gets its own fuzzer.
+	Instantiations [][]Type
+
+	// The range of lengths of generated variadic arguments. If
+	// nil, the default is used.
+	VariadicLength *LengthRange
+
+	// The maximum length of generated strings, slices, and maps. If
+	// zero, the default is used.
+	Size uint
+
+	// The probability of generating a boundary value of a builtin
+	// type, or a boundary length. If nil, the default is used.
+	EdgeCases *float64
+}
+
+// LengthRange is an inclusive range of lengths.
+type LengthRange struct {
+	Min uint
+	Max uint
+}
+
+// Invariant is a property which must hold after every operation.
+type Invariant struct {
+	// The expression, with "%var" standing for the implementation.
+	Expression string
+
+	// If true, the invariant is checked for the reference
+	// implementation as well as for the test implementation.
+	Both bool
 }
 
 // Generator is the name of a function to generate a value of a given
-// type.
+// type, or an expression to do so.
 type Generator struct {
 	// True if this is stateful.
 	IsStateful bool
 
+	// True if this is passed the size of generated values.
+	IsSized bool
+
+	// The initial state, if this is stateful and has a state of its
+	// own, rather than sharing the "@generator state".
+	State string
+
+	// The function itself.
+	Name string
+
+	// The expression, if this is an expression rather than a
+	// function, with "%rand" standing for the PRNG and "%size" for
+	// the size of generated values.
+	Expression string
+
+	// The regular expression the generated strings match, if this
+	// is given by one.
+	Regexp string
+
+	// The grammar the generated strings are derived from, if this
+	// is given by one.
+	Grammar *Grammar
+
+	// The type of the generated values.
+	Type Type
+
+	// The type, field, or method parameter this generates values
+	// for, which is set when the generator is looked up, and names
+	// its own state.
+	target string
+}
+
+// FieldGenerator is the name of a function to generate one field of
+// a struct type, used when the whole struct is generated by default.
+type FieldGenerator struct {
+	// True if this is stateful.
+	IsStateful bool
+
+	// True if this is passed the size of generated values.
+	IsSized bool
+
+	// The initial state, if this is stateful and has a state of its
+	// own, rather than sharing the "@generator state".
+	State string
+
 	// The function itself.
 	Name string
+
+	// The expression, if this is an expression rather than a
+	// function.
+	Expression string
+
+	// The struct type.
+	Type Type
+
+	// The name of the field.
+	Field string
+}
+
+// Generator gets the generator for a field, as if it were for the
+// type of the field.
+func (fieldgen FieldGenerator) Generator() Generator {
+	return Generator{IsStateful: fieldgen.IsStateful, IsSized: fieldgen.IsSized, Name: fieldgen.Name, Expression: fieldgen.Expression, State: fieldgen.State, target: fieldgen.Type.ToString() + "." + fieldgen.Field}
+}
+
+// Shrinker is the name of a function to produce smaller variants of a
+// value of a given type, to simplify failing operations.
+type Shrinker struct {
+	// The function itself.
+	Name string
+
+	// The type of the shrunk values.
+	Type Type
+}
+
+// Reuse is a type of which values returned by earlier operations are
+// kept in a pool, to be reused as the arguments of later ones.
+type Reuse struct {
+	// The type of the values.
+	Type Type
+
+	// The probability of drawing an argument from the pool, rather
+	// than generating it, when the pool is not empty.
+	Probability float64
+}
+
+// Enum is a type with a fixed set of values, which generated values of
+// the type are usually drawn from.
+type Enum struct {
+	// The type of the values.
+	Type Type
+
+	// The values, as Go expressions. If empty, the constants
+	// declared with the type are used.
+	Values []string
+
+	// The probability of generating a value as usual, which is
+	// probably not one of the set, rather than drawing from it.
+	OutOfRange float64
+}
+
+// Grammar is a grammar of strings, which are derived from its first
+// rule.
+type Grammar struct {
+	Rules []GrammarRule
+}
+
+// GrammarRule is a rule of a grammar, with the alternatives it may be
+// replaced by.
+type GrammarRule struct {
+	// The name of the rule.
+	Name string
+
+	// The alternatives, each a sequence of symbols.
+	Alternatives [][]GrammarSymbol
+}
+
+// GrammarSymbol is a symbol in an alternative of a grammar rule:
+// another rule, some text, or a regular expression. If all are empty,
+// it is the empty text.
+type GrammarSymbol struct {
+	// The name of the rule.
+	Rule string
+
+	// The text.
+	Text string
+
+	// The regular expression.
+	Regexp string
+}
+
+// AddRule adds a rule to a grammar. If there is already a rule with
+// the same name, the alternatives are added to it instead.
+func (grammar *Grammar) AddRule(rule GrammarRule) {
+	for i := range grammar.Rules {
+		if grammar.Rules[i].Name == rule.Name {
+			grammar.Rules[i].Alternatives = append(grammar.Rules[i].Alternatives, rule.Alternatives...)
+			return
+		}
+	}
+	grammar.Rules = append(grammar.Rules, rule)
 }
 
 // EitherFunctionOrMethod is either a function or a method. Param and
@@ -147,9 +347,6 @@ func WantedFuzzersFromCommentLines(commentLines []string) ([]WantedFuzzer, error
 
 			if fuzzing {
 				// Found a new fuzzer! Add the old one to the list.
-				if fuzzer.Reference.Name == "" {
-					return fuzzers, fmt.Errorf("fuzzer declaration for %s missing '@known correct' line", fuzzer.InterfaceName)
-				}
 				fuzzers = append(fuzzers, fuzzer)
 
 			}
@@ -157,9 +354,14 @@ func WantedFuzzersFromCommentLines(commentLines []string) ([]WantedFuzzer, error
 			var name string
 			name, err = parseFuzzInterface(suff)
 			fuzzer = WantedFuzzer{
-				InterfaceName: name,
-				Comparison:    make(map[string]EitherFunctionOrMethod),
-				Generator:     make(map[string]Generator),
+				InterfaceName:  name,
+				Comparison:     make(map[string]EitherFunctionOrMethod),
+				Generator:      make(map[string]Generator),
+				FieldGenerator: make(map[string]FieldGenerator),
+				ParamGenerator: make(map[string]Generator),
+				Shrinker:       make(map[string]Shrinker),
+				Reuse:          make(map[string]Reuse),
+				Enum:           make(map[string]Enum),
 			}
 			fuzzing = true
 		}
@@ -172,9 +374,6 @@ func WantedFuzzersFromCommentLines(commentLines []string) ([]WantedFuzzer, error
 
 	if fuzzing {
 		// Add the final fuzzer to the list.
-		if fuzzer.Reference.Name == "" {
-			return fuzzers, fmt.Errorf("fuzzer declaration for %s missing '@known correct' line", fuzzer.InterfaceName)
-		}
 		return append(fuzzers, fuzzer), nil
 	}
 
@@ -186,9 +385,21 @@ and mutate the wanted fuzzer; if not, skip over.
 
 SYNTAX: @known correct:   <parseKnownCorrect>
       | @invariant:       <parseInvariant>
+      | @invariant both:  <parseInvariant>
       | @comparison:      <parseComparison>
       | @generator:       <parseGenerator>
+      | @generator field: <parseFieldGenerator>
+      | @generator Method.param: <parseParamGenerator>
+      | @generator regex: <parseRegexGenerator>
+      | @generator grammar: <parseGrammarRule>
       | @generator state: <parseGeneratorState>
+      | @shrinker:        <parseShrinker>
+      | @reuse:           <parseReuse>
+      | @enum:            <parseEnum>
+      | @instantiate:     <parseInstantiate>
+      | @variadic:        <parseVariadic>
+      | @size:            <parseSize>
+      | @edge cases:      <parseEdgeCases>
 */
 func parseLine(line string, fuzzer *WantedFuzzer) error {
 	// "@known correct:"
@@ -213,7 +424,18 @@ func parseLine(line string, fuzzer *WantedFuzzer) error {
 			return err
 		}
 
-		fuzzer.Invariants = append(fuzzer.Invariants, inv)
+		fuzzer.Invariants = append(fuzzer.Invariants, Invariant{Expression: inv})
+	}
+
+	// "@invariant both:"
+	suff, ok = matchPrefix(line, "@invariant both:")
+	if ok {
+		inv, err := parseInvariant(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.Invariants = append(fuzzer.Invariants, Invariant{Expression: inv, Both: true})
 	}
 
 	// "@comparison:"
@@ -230,12 +452,68 @@ func parseLine(line string, fuzzer *WantedFuzzer) error {
 	// "@generator:"
 	suff, ok = matchPrefix(line, "@generator:")
 	if ok {
-		tyname, genfunc, stateful, err := parseGenerator(suff)
+		generator, err := parseGenerator(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.Generator[generator.Type.ToString()] = generator
+	}
+
+	// "@generator field:"
+	suff, ok = matchPrefix(line, "@generator field:")
+	if ok {
+		fieldgen, err := parseFieldGenerator(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.FieldGenerator[fieldgen.Type.ToString()+"."+fieldgen.Field] = fieldgen
+	}
+
+	// "@generator Method.param:"
+	suff, ok = matchPrefix(line, "@generator ")
+	if ok {
+		method, rest := parseName(suff)
+		rest, dot := matchPrefix(rest, ".")
+		param, rest := parseName(rest)
+		rest, colon := matchPrefix(rest, ":")
+		if dot && colon && method != "" && param != "" {
+			generator, err := parseParamGenerator(rest)
+			if err != nil {
+				return err
+			}
+
+			fuzzer.ParamGenerator[method+"."+param] = generator
+		}
+	}
+
+	// "@generator regex:"
+	suff, ok = matchPrefix(line, "@generator regex:")
+	if ok {
+		generator, err := parseRegexGenerator(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.Generator[generator.Type.ToString()] = generator
+	}
+
+	// "@generator grammar:"
+	suff, ok = matchPrefix(line, "@generator grammar:")
+	if ok {
+		ty, rule, err := parseGrammarRule(suff)
 		if err != nil {
 			return err
 		}
 
-		fuzzer.Generator[tyname.ToString()] = Generator{IsStateful: stateful, Name: genfunc}
+		// The rules of a grammar are given one per line.
+		generator := fuzzer.Generator[ty.ToString()]
+		if generator.Grammar == nil {
+			generator = Generator{Grammar: &Grammar{}, Type: ty}
+		}
+		generator.Grammar.AddRule(rule)
+		fuzzer.Generator[ty.ToString()] = generator
 	}
 
 	// "@generator state:"
@@ -249,6 +527,86 @@ func parseLine(line string, fuzzer *WantedFuzzer) error {
 		fuzzer.GeneratorState = state
 	}
 
+	// "@shrinker:"
+	suff, ok = matchPrefix(line, "@shrinker:")
+	if ok {
+		tyname, shrinkfunc, err := parseShrinker(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.Shrinker[tyname.ToString()] = Shrinker{Name: shrinkfunc, Type: tyname}
+	}
+
+	// "@reuse:"
+	suff, ok = matchPrefix(line, "@reuse:")
+	if ok {
+		tyname, probability, err := parseReuse(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.Reuse[tyname.ToString()] = Reuse{Type: tyname, Probability: probability}
+	}
+
+	// "@enum:"
+	suff, ok = matchPrefix(line, "@enum:")
+	if ok {
+		enum, err := parseEnum(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.Enum[enum.Type.ToString()] = enum
+	}
+
+	// "@instantiate:"
+	suff, ok = matchPrefix(line, "@instantiate:")
+	if ok {
+		name, args, err := parseInstantiate(suff)
+		if err != nil {
+			return err
+		}
+		if name != fuzzer.InterfaceName {
+			return fmt.Errorf("cannot instantiate '%s' in fuzzer for '%s'", name, fuzzer.InterfaceName)
+		}
+
+		fuzzer.Instantiations = append(fuzzer.Instantiations, args)
+	}
+
+	// "@variadic:"
+	suff, ok = matchPrefix(line, "@variadic:")
+	if ok {
+		length, err := parseVariadic(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.VariadicLength = &length
+	}
+
+	// "@size:"
+	suff, ok = matchPrefix(line, "@size:")
+	if ok {
+		size, err := parseSize(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.Size = size
+	}
+
+	// "@edge cases:"
+	suff, ok = matchPrefix(line, "@edge cases:")
+	if ok {
+		bias, err := parseEdgeCases(suff)
+		if err != nil {
+			return err
+		}
+
+		fuzzer.EdgeCases = &bias
+	}
+
 	return nil
 }
 
@@ -329,28 +687,329 @@ func parseComparison(line string) (Type, EitherFunctionOrMethod, error) {
 
 // Parse a "@generator:"
 //
-// SYNTAX: [!] FunctionName Type
-func parseGenerator(line string) (Type, string, bool, error) {
+// SYNTAX: [!] [~] FunctionName Type [state=Expression]
+//       | expr Expression
+func parseGenerator(line string) (Generator, error) {
+	// expr Expression
+	if expr, ok := matchKeyword(line, "expr"); ok {
+		ty, err := parseGeneratorExpression(expr, line)
+		if err != nil {
+			return Generator{}, err
+		}
+		if ty == nil {
+			return Generator{}, fmt.Errorf("expected a conversion or composite literal giving the type in '%s'", line)
+		}
+		return Generator{Expression: expr, Type: ty}, nil
+	}
+
 	// [!]
 	rest, stateful := matchPrefix(line, "!")
 
+	// [~]
+	rest, sized := matchPrefix(rest, "~")
+
 	// FunctionName
 	var name string
 	name, rest = parseFunctionName(rest)
 
 	if name == "" {
-		return nil, name, stateful, fmt.Errorf("expected a name in '%s'", line)
+		return Generator{}, fmt.Errorf("expected a name in '%s'", line)
 	}
 
 	var err error
 	var ty Type
-	ty, rest, err = parseType(rest)
+	ty, rest, err = parseGeneratorType(rest)
+	if err != nil {
+		return Generator{}, err
+	}
+
+	// [state=Expression]
+	state, err := parseOwnState(rest, line, stateful)
+
+	return Generator{IsStateful: stateful, IsSized: sized, Name: name, Type: ty, State: state}, err
+}
 
+// Parse the type of a "@generator:", which may be followed by the
+// initial state. As the state expression need not be a valid type, it
+// is split off first.
+func parseGeneratorType(s string) (Type, string, error) {
+	end := len(s)
+	for i := 1; i < len(s); i++ {
+		if unicode.IsSpace(rune(s[i-1])) && strings.HasPrefix(s[i:], "state=") {
+			end = i
+			break
+		}
+	}
+
+	ty, rest, err := parseType(s[:end])
+	if err != nil {
+		return ty, rest, err
+	}
 	if rest != "" {
-		err = fmt.Errorf("unexpected left over input in '%s' (got '%s')", line, rest)
+		return ty, rest, fmt.Errorf("unexpected left over input in '%s' (got '%s')", s, rest)
+	}
+	return ty, s[end:], nil
+}
+
+// Parse the initial state of a stateful generator with a state of its
+// own.
+//
+// SYNTAX: [state=Expression]
+func parseOwnState(s, line string, stateful bool) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	state, ok := matchPrefix(s, "state=")
+	if !ok {
+		return "", fmt.Errorf("unexpected left over input in '%s' (got '%s')", line, s)
+	}
+	if !stateful {
+		return "", fmt.Errorf("initial state given for a generator which is not stateful in '%s'", line)
+	}
+	if state == "" {
+		return "", fmt.Errorf("expected an initial state in '%s'", line)
+	}
+	return state, nil
+}
+
+// Parse a "@generator field:"
+//
+// SYNTAX: Type.Field [!] [~] FunctionName [state=Expression]
+//       | Type.Field expr Expression
+func parseFieldGenerator(line string) (FieldGenerator, error) {
+	var fieldgen FieldGenerator
+
+	// Type.Field
+	end := strings.IndexFunc(line, unicode.IsSpace)
+	if end < 0 {
+		return fieldgen, fmt.Errorf("expected a field and a name in '%s'", line)
+	}
+	dot := strings.LastIndex(line[:end], ".")
+	if dot < 0 {
+		return fieldgen, fmt.Errorf("expected a field in '%s'", line)
+	}
+
+	ty, rest, err := parseType(line[:dot])
+	if err != nil {
+		return fieldgen, err
+	}
+	if ty.ToString() == "" || rest != "" {
+		return fieldgen, fmt.Errorf("expected a type in '%s'", line)
+	}
+	field, rest := parseName(line[dot+1 : end])
+	if field == "" || rest != "" {
+		return fieldgen, fmt.Errorf("expected a field in '%s'", line)
+	}
+
+	generator, err := parseUntypedGenerator(strings.TrimLeftFunc(line[end:], unicode.IsSpace), line)
+	if err != nil {
+		return fieldgen, err
+	}
+
+	fieldgen = FieldGenerator{
+		IsStateful: generator.IsStateful,
+		IsSized:    generator.IsSized,
+		State:      generator.State,
+		Name:       generator.Name,
+		Expression: generator.Expression,
+		Type:       ty,
+		Field:      field,
+	}
+	return fieldgen, nil
+}
+
+// Parse a "@generator Method.param:"
+//
+// SYNTAX: [!] [~] FunctionName [state=Expression]
+//       | expr Expression
+func parseParamGenerator(line string) (Generator, error) {
+	return parseUntypedGenerator(line, line)
+}
+
+// Parse a generator for something other than a type, which therefore
+// doesn't need to give the type of the generated values.
+//
+// SYNTAX: [!] [~] FunctionName [state=Expression]
+//       | expr Expression
+func parseUntypedGenerator(s, line string) (Generator, error) {
+	// expr Expression
+	if expr, ok := matchKeyword(s, "expr"); ok {
+		if _, err := parseGeneratorExpression(expr, line); err != nil {
+			return Generator{}, err
+		}
+		return Generator{Expression: expr}, nil
+	}
+
+	// [!]
+	rest, stateful := matchPrefix(s, "!")
+
+	// [~]
+	rest, sized := matchPrefix(rest, "~")
+
+	// FunctionName
+	name, rest := parseFunctionName(rest)
+	if name == "" {
+		return Generator{}, fmt.Errorf("expected a name in '%s'", line)
+	}
+
+	// [state=Expression]
+	state, err := parseOwnState(strings.TrimSpace(rest), line, stateful)
+	if err != nil {
+		return Generator{}, err
 	}
 
-	return ty, name, stateful, err
+	return Generator{IsStateful: stateful, IsSized: sized, Name: name, State: state}, nil
+}
+
+// Parse an inline generator expression, checking that it is valid Go
+// once "%rand" and "%size" are substituted. If the expression is a
+// conversion or a composite literal, the type of the generated values
+// is returned; otherwise it is nil.
+func parseGeneratorExpression(expr, line string) (Type, error) {
+	// The placeholders are unlike any type name, so a call which
+	// uses them as the function isn't taken to be a conversion.
+	src := strings.NewReplacer("%rand", "__rand__", "%size", "__size__").Replace(expr)
+	parsed, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression in '%s': %s", line, err)
+	}
+
+	var tyexpr ast.Expr
+	switch x := parsed.(type) {
+	case *ast.CallExpr:
+		if len(x.Args) == 1 {
+			tyexpr = x.Fun
+		}
+	case *ast.CompositeLit:
+		tyexpr = x.Type
+	}
+	if tyexpr == nil {
+		return nil, nil
+	}
+
+	// Positions are 1-based.
+	tysrc := src[tyexpr.Pos()-1 : tyexpr.End()-1]
+	if strings.Contains(tysrc, "__rand__") || strings.Contains(tysrc, "__size__") {
+		return nil, nil
+	}
+	ty, rest, err := parseType(tysrc)
+	if err != nil || rest != "" || ty.ToString() == "" {
+		return nil, nil
+	}
+	return ty, nil
+}
+
+// Parse a "@generator regex:"
+//
+// SYNTAX: Type StringLiteral
+func parseRegexGenerator(line string) (Generator, error) {
+	// The pattern may not be a valid type, so split the line at the
+	// start of the string literal before parsing the type.
+	start := strings.IndexAny(line, "\"`")
+	if start < 0 {
+		return Generator{}, fmt.Errorf("expected a regular expression in '%s'", line)
+	}
+
+	ty, rest, err := parseType(line[:start])
+	if err != nil {
+		return Generator{}, err
+	}
+	if ty.ToString() == "" || rest != "" {
+		return Generator{}, fmt.Errorf("expected a type in '%s'", line)
+	}
+
+	pattern, err := strconv.Unquote(strings.TrimSpace(line[start:]))
+	if err != nil {
+		return Generator{}, fmt.Errorf("expected a regular expression in '%s'", line)
+	}
+	if _, err := syntax.Parse(pattern, syntax.Perl); err != nil {
+		return Generator{}, fmt.Errorf("invalid regular expression in '%s': %s", line, err)
+	}
+
+	return Generator{Regexp: pattern, Type: ty}, nil
+}
+
+// Parse a "@generator grammar:", which gives one rule of the grammar
+// for a type. Symbols are the names of other rules, string literals
+// for text, and raw string literals for regular expressions.
+//
+// SYNTAX: Type Name = Alternative {| Alternative}
+//
+// Alternative = Symbol {Symbol}
+//
+// Symbol = Name | StringLiteral | RawStringLiteral
+func parseGrammarRule(line string) (Type, GrammarRule, error) {
+	var rule GrammarRule
+
+	// The rule may not be a valid type, so split the line at the
+	// first space before parsing the type.
+	end := strings.IndexFunc(line, unicode.IsSpace)
+	if end < 0 {
+		return nil, rule, fmt.Errorf("expected a grammar rule in '%s'", line)
+	}
+
+	ty, rest, err := parseType(line[:end])
+	if err != nil {
+		return nil, rule, err
+	}
+	if ty.ToString() == "" || rest != "" {
+		return nil, rule, fmt.Errorf("expected a type in '%s'", line)
+	}
+
+	// The rule is made up of Go tokens.
+	src := []byte(line[end:])
+	var scan scanner.Scanner
+	var scanErr error
+	scan.Init(token.NewFileSet().AddFile("", -1, len(src)), src, func(_ token.Position, msg string) {
+		scanErr = fmt.Errorf("invalid grammar rule in '%s': %s", line, msg)
+	}, 0)
+
+	var alternative []GrammarSymbol
+	for {
+		_, tok, lit := scan.Scan()
+		if scanErr != nil {
+			return nil, rule, scanErr
+		}
+
+		switch {
+		case rule.Name == "":
+			if tok != token.IDENT {
+				return nil, rule, fmt.Errorf("expected a rule name in '%s'", line)
+			}
+			rule.Name = lit
+			if _, tok, _ = scan.Scan(); tok != token.ASSIGN {
+				return nil, rule, fmt.Errorf("expected '=' after the rule name in '%s'", line)
+			}
+
+		case tok == token.IDENT:
+			alternative = append(alternative, GrammarSymbol{Rule: lit})
+
+		case tok == token.STRING && lit[0] == '`':
+			pattern, _ := strconv.Unquote(lit)
+			if _, err := syntax.Parse(pattern, syntax.Perl); err != nil {
+				return nil, rule, fmt.Errorf("invalid regular expression in '%s': %s", line, err)
+			}
+			alternative = append(alternative, GrammarSymbol{Regexp: pattern})
+
+		case tok == token.STRING:
+			text, _ := strconv.Unquote(lit)
+			alternative = append(alternative, GrammarSymbol{Text: text})
+
+		case tok == token.OR || tok == token.EOF || tok == token.SEMICOLON && lit == "\n":
+			if len(alternative) == 0 {
+				return nil, rule, fmt.Errorf("expected a symbol in '%s'", line)
+			}
+			rule.Alternatives = append(rule.Alternatives, alternative)
+			alternative = nil
+			if tok != token.OR {
+				return ty, rule, nil
+			}
+
+		default:
+			return nil, rule, fmt.Errorf("unexpected '%s' in grammar rule '%s'", tok, line)
+		}
+	}
 }
 
 // Parse a "@generator state:"
@@ -367,6 +1026,206 @@ func parseGeneratorState(line string) (string, error) {
 	return line, nil
 }
 
+// Parse a "@shrinker:"
+//
+// SYNTAX: FunctionName Type
+func parseShrinker(line string) (Type, string, error) {
+	// FunctionName
+	name, rest := parseFunctionName(line)
+
+	if name == "" {
+		return nil, name, fmt.Errorf("expected a name in '%s'", line)
+	}
+	if rest == "" {
+		return nil, name, fmt.Errorf("expected a type in '%s'", line)
+	}
+
+	ty, rest, err := parseType(rest)
+
+	if rest != "" {
+		err = fmt.Errorf("unexpected left over input in '%s' (got '%s')", line, rest)
+	}
+
+	return ty, name, err
+}
+
+// Parse a "@reuse:"
+//
+// SYNTAX: Type Probability
+func parseReuse(line string) (Type, float64, error) {
+	ty, rest, err := parseType(line)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ty.ToString() == "" {
+		return nil, 0, fmt.Errorf("expected a type in '%s'", line)
+	}
+
+	probability, err := strconv.ParseFloat(strings.TrimSpace(rest), 64)
+	if err != nil || !(probability >= 0 && probability <= 1) {
+		return nil, 0, fmt.Errorf("expected a probability between 0 and 1 in '%s'", line)
+	}
+
+	return ty, probability, nil
+}
+
+// Parse an "@enum:"
+//
+// SYNTAX: Type Probability [Value1, ..., ValueN]
+func parseEnum(line string) (Enum, error) {
+	var enum Enum
+
+	// The values may not be valid types, so split the line at the
+	// probability before parsing the type.
+	start, end := -1, -1
+	for i := 1; i < len(line); i++ {
+		if !unicode.IsSpace(rune(line[i-1])) || unicode.IsSpace(rune(line[i])) {
+			continue
+		}
+		end = strings.IndexFunc(line[i:], unicode.IsSpace)
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += i
+		}
+		if _, err := strconv.ParseFloat(line[i:end], 64); err == nil {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return enum, fmt.Errorf("expected a probability between 0 and 1 in '%s'", line)
+	}
+
+	ty, rest, err := parseType(line[:start])
+	if err != nil {
+		return enum, err
+	}
+	if ty.ToString() == "" || rest != "" {
+		return enum, fmt.Errorf("expected a type in '%s'", line)
+	}
+
+	probability, _ := strconv.ParseFloat(line[start:end], 64)
+	if !(probability >= 0 && probability <= 1) {
+		return enum, fmt.Errorf("expected a probability between 0 and 1 in '%s'", line)
+	}
+	rest = line[end:]
+
+	// The values are parsed as the elements of a composite
+	// literal, so they can be any expressions.
+	var values []string
+	if src := strings.TrimSpace(rest); src != "" {
+		expr, err := parser.ParseExpr("[]T{" + src + "}")
+		if err != nil {
+			return enum, fmt.Errorf("expected values in '%s': %s", line, err)
+		}
+		lit, ok := expr.(*ast.CompositeLit)
+		if !ok {
+			return enum, fmt.Errorf("expected values in '%s'", line)
+		}
+		for _, elt := range lit.Elts {
+			if _, ok := elt.(*ast.KeyValueExpr); ok {
+				return enum, fmt.Errorf("expected values in '%s'", line)
+			}
+			// Positions are 1-based, and offset by the "[]T{".
+			values = append(values, src[elt.Pos()-5:elt.End()-5])
+		}
+	}
+
+	enum = Enum{Type: ty, Values: values, OutOfRange: probability}
+	return enum, nil
+}
+
+// Parse an "@instantiate:"
+//
+// SYNTAX: Name[Type1, ..., TypeN]
+func parseInstantiate(line string) (string, []Type, error) {
+	name, rest := parseName(line)
+	if name == "" {
+		return name, nil, fmt.Errorf("expected a name in '%s'", line)
+	}
+
+	rest, ok := matchPrefix(rest, "[")
+	if !ok {
+		return name, nil, fmt.Errorf("expected type arguments in '%s'", line)
+	}
+
+	var args []Type
+	for {
+		argty, argRest, err := parseType(rest)
+		if err != nil {
+			return name, args, err
+		}
+		args = append(args, argty)
+
+		if rest, ok = matchPrefix(argRest, ","); ok {
+			continue
+		}
+		if rest, ok = matchPrefix(argRest, "]"); ok {
+			break
+		}
+		return name, args, fmt.Errorf("mismatched brackets in '%s'", line)
+	}
+
+	if rest != "" {
+		return name, args, fmt.Errorf("unexpected left over input in '%s' (got '%s')", line, rest)
+	}
+
+	return name, args, nil
+}
+
+// Parse a "@variadic:"
+//
+// SYNTAX: Min Max
+func parseVariadic(line string) (LengthRange, error) {
+	var length LengthRange
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return length, fmt.Errorf("expected a minimum and maximum length in '%s'", line)
+	}
+
+	min, err := strconv.ParseUint(fields[0], 10, 0)
+	if err != nil {
+		return length, fmt.Errorf("invalid minimum length in '%s'", line)
+	}
+	max, err := strconv.ParseUint(fields[1], 10, 0)
+	if err != nil {
+		return length, fmt.Errorf("invalid maximum length in '%s'", line)
+	}
+	if min > max {
+		return length, fmt.Errorf("minimum length greater than maximum in '%s'", line)
+	}
+
+	length.Min = uint(min)
+	length.Max = uint(max)
+	return length, nil
+}
+
+// Parse a "@size:"
+//
+// SYNTAX: Size
+func parseSize(line string) (uint, error) {
+	size, err := strconv.ParseUint(strings.TrimSpace(line), 10, 0)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("expected a positive size in '%s'", line)
+	}
+
+	return uint(size), nil
+}
+
+// Parse an "@edge cases:"
+//
+// SYNTAX: Probability
+func parseEdgeCases(line string) (float64, error) {
+	bias, err := strconv.ParseFloat(strings.TrimSpace(line), 64)
+	if err != nil || !(bias >= 0 && bias <= 1) {
+		return 0, fmt.Errorf("expected a probability between 0 and 1 in '%s'", line)
+	}
+
+	return bias, nil
+}
+
 // Parse an "@invariant:"
 //
 // This does absolutely NO checking whatsoever beyond presence
@@ -448,7 +1307,9 @@ func parseFunctionName(line string) (string, string) {
 // Parse a type. This is very stupid and doesn't make much effort to
 // be absolutely correct.
 //
-// SYNTAX: []Type | chan Type | map[Type]Type | *Type | (Type) | Name.Type | Name
+// SYNTAX: []Type | [Length]Type | chan Type | chan<- Type | <-chan Type
+//       | map[Type]Type | *Type | struct{...} | interface{...} | (Type)
+//       | Name.Type | Name
 func parseType(s string) (Type, string, error) {
 	// Array type
 	suff, ok := matchPrefix(s, "[]")
@@ -460,14 +1321,47 @@ func parseType(s string) (Type, string, error) {
 		return parseUnaryType(tycon, suff, s)
 	}
 
-	// Chan type
-	suff, ok = matchPrefix(s, "chan")
-	if ok {
+	// Fixed-size array type
+	if end := matchBracket(s, '[', ']'); end > 0 {
+		length := strings.TrimSpace(s[1:end])
 		tycon := func(t Type) Type {
-			ty := ChanType{ElementType: t}
+			ty := ArrayType{ElementType: t, Length: length}
 			return &ty
 		}
-		return parseUnaryType(tycon, suff, s)
+		return parseUnaryType(tycon, strings.TrimLeftFunc(s[end+1:], unicode.IsSpace), s)
+	}
+
+	// Chan types
+	for _, prefix := range []struct {
+		keyword string
+		dir     ChanDir
+	}{{"chan<-", ChanSend}, {"<-chan", ChanRecv}, {"chan", ChanBoth}} {
+		suff, ok = matchPrefix(s, prefix.keyword)
+		if ok {
+			dir := prefix.dir
+			tycon := func(t Type) Type {
+				ty := ChanType{ElementType: t, Dir: dir}
+				return &ty
+			}
+			return parseUnaryType(tycon, suff, s)
+		}
+	}
+
+	// Struct and interface types
+	for _, keyword := range []string{"struct", "interface"} {
+		suff, ok = matchPrefix(s, keyword)
+		if ok && strings.HasPrefix(suff, "{") {
+			end := matchBracket(suff, '{', '}')
+			if end < 0 {
+				return nil, s, fmt.Errorf("mismatched braces in '%s'", s)
+			}
+			expr, err := parser.ParseExpr(keyword + suff[:end+1])
+			if err != nil {
+				return nil, s, fmt.Errorf("invalid %s type in '%s': %s", keyword, s, err)
+			}
+			rest := strings.TrimLeftFunc(suff[end+1:], unicode.IsSpace)
+			return TypeFromTypeExpr(expr), rest, nil
+		}
 	}
 
 	// Map type
@@ -522,7 +1416,7 @@ func parseType(s string) (Type, string, error) {
 	return nil, s, fmt.Errorf("mismatched parentheses in '%s'", s)
 }
 
-// Helper function for parsing a unary type operator: [], chan, or *.
+// Helper function for parsing a unary type operator: [], [N], chan, or *.
 //
 // SYNTAX: Type
 func parseUnaryType(tycon func(Type) Type, s, orig string) (Type, string, error) {
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Check that "@instantiate" lines are parsed and can be repeated.
+func TestInstantiate(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Cache",
+		"@known correct: newCache",
+		"@instantiate: Cache[string, int]",
+		"@instantiate: Cache[int, []byte]",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wanteds) != 1 {
+		t.Fatalf("Expected one fuzzer, got %d.", len(wanteds))
+	}
+
+	expected := [][]string{{"string", "int"}, {"int", "[](byte)"}}
+	actual := wanteds[0].Instantiations
+	if len(actual) != len(expected) {
+		expectedActual("Wrong number of instantiations.", len(expected), len(actual), t)
+	}
+	for i, args := range expected {
+		if len(actual[i]) != len(args) {
+			expectedActual("Wrong number of type arguments.", len(args), len(actual[i]), t)
+		}
+		for j, arg := range args {
+			if actual[i][j].ToString() != arg {
+				expectedActual("Wrong type argument.", arg, actual[i][j].ToString(), t)
+			}
+		}
+	}
+}
+
+// Check that malformed "@instantiate" lines are rejected.
+func TestInstantiateInvalid(t *testing.T) {
+	for _, line := range []string{
+		"@instantiate: Cache",
+		"@instantiate: Cache[string, int",
+		"@instantiate: Cache[string] extra",
+		"@instantiate: Other[string]",
+	} {
+		lines := []string{"@fuzz interface: Cache", "@known correct: newCache", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "@variadic" lines give a length range.
+func TestVariadic(t *testing.T) {
+	lines := []string{"@fuzz interface: Queue", "@known correct: newQueue", "@variadic: 1 8"}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length := wanteds[0].VariadicLength
+	if length == nil || length.Min != 1 || length.Max != 8 {
+		expectedActual("Wrong variadic length.", LengthRange{Min: 1, Max: 8}, length, t)
+	}
+
+	for _, line := range []string{"@variadic: 8 1", "@variadic: 1", "@variadic: a b"} {
+		lines := []string{"@fuzz interface: Queue", "@known correct: newQueue", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that composite types in special comments are parsed.
+func TestCompositeGeneratorTypes(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Grid",
+		"@known correct: newGrid",
+		"@generator: genCells [N]byte",
+		"@generator: genPair struct{A int; B string}",
+		"@generator: genSource <-chan int",
+		"@generator: genSink chan<- int",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"[N](byte)":               "genCells",
+		"struct{A int; B string}": "genPair",
+		"<-chan (int)":            "genSource",
+		"chan<- (int)":            "genSink",
+	}
+	generators := wanteds[0].Generator
+	if len(generators) != len(expected) {
+		expectedActual("Wrong number of generators.", len(expected), len(generators), t)
+	}
+	for tystr, name := range expected {
+		if generators[tystr].Name != name {
+			expectedActual("Wrong generator for "+tystr+".", name, generators[tystr].Name, t)
+		}
+	}
+}
+
+// Check that a fuzzer without a "@known correct" line is accepted, for
+// checking invariants only.
+func TestNoKnownCorrect(t *testing.T) {
+	lines := []string{"@fuzz interface: Counter", "@invariant: %var.Count() >= 0"}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wanteds) != 1 {
+		t.Fatalf("Expected one fuzzer, got %d.", len(wanteds))
+	}
+	if wanteds[0].Reference.Name != "" {
+		expectedActual("Unexpected reference function.", "", wanteds[0].Reference.Name, t)
+	}
+	if len(wanteds[0].Invariants) != 1 {
+		expectedActual("Wrong number of invariants.", 1, len(wanteds[0].Invariants), t)
+	}
+}
+
+// Check that "@invariant both" lines are distinguished from
+// "@invariant" lines.
+func TestInvariantBoth(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Counter",
+		"@invariant: %var.Count() >= 0",
+		"@invariant both: %var.Count() < 100",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Invariant{
+		{Expression: "%var.Count() >= 0"},
+		{Expression: "%var.Count() < 100", Both: true},
+	}
+	actual := wanteds[0].Invariants
+	if len(actual) != len(expected) {
+		expectedActual("Wrong number of invariants.", expected, actual, t)
+	}
+	for i, invariant := range expected {
+		if actual[i] != invariant {
+			expectedActual("Wrong invariant.", invariant, actual[i], t)
+		}
+	}
+}
+
+// Check that "@shrinker" lines are parsed, and rejected without a
+// type.
+func TestShrinker(t *testing.T) {
+	lines := []string{"@fuzz interface: Store", "@shrinker: shrinkMessage model.Message"}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shrinker, ok := wanteds[0].Shrinker["model.Message"]
+	if !ok || shrinker.Name != "shrinkMessage" {
+		expectedActual("Wrong shrinker.", "shrinkMessage", shrinker.Name, t)
+	}
+
+	lines = []string{"@fuzz interface: Store", "@shrinker: shrinkMessage"}
+	if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+		t.Fatal("Expected an error parsing a shrinker without a type.")
+	}
+}
+
+// Check that "@reuse" lines are parsed, and rejected without a type
+// or with a probability outside [0, 1].
+func TestReuse(t *testing.T) {
+	lines := []string{"@fuzz interface: Store", "@reuse: []ID 0.25"}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reuse, ok := wanteds[0].Reuse["[](ID)"]
+	if !ok || reuse.Probability != 0.25 {
+		expectedActual("Wrong reuse.", Reuse{Probability: 0.25}, reuse, t)
+	}
+
+	for _, line := range []string{"@reuse: ID 0", "@reuse: ID 1"} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err != nil {
+			t.Fatalf("Unexpected error parsing '%s': %s", line, err)
+		}
+	}
+
+	for _, line := range []string{"@reuse: 0.5", "@reuse: ID", "@reuse: ID 1.5", "@reuse: ID -0.25", "@reuse: ID NaN", "@reuse: ID +Inf", "@reuse: ID often", "@reuse: ID 0.5 0.5", "@reuse: ID 50%"} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "@size" lines give a positive size.
+func TestSize(t *testing.T) {
+	lines := []string{"@fuzz interface: Queue", "@known correct: newQueue", "@size: 32"}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wanteds[0].Size != 32 {
+		expectedActual("Wrong size.", 32, wanteds[0].Size, t)
+	}
+
+	for _, line := range []string{"@size: 0", "@size: -1", "@size: big"} {
+		lines := []string{"@fuzz interface: Queue", "@known correct: newQueue", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "@generator field" lines are keyed by type and field,
+// and rejected without a field or a name.
+func TestFieldGenerator(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		"@generator field: model.Message.ID generateID",
+		"@generator field: Message.Body ! generateBody",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]FieldGenerator{
+		"model.Message.ID": {Name: "generateID", Field: "ID"},
+		"Message.Body":     {IsStateful: true, Name: "generateBody", Field: "Body"},
+	}
+	generators := wanteds[0].FieldGenerator
+	if len(generators) != len(expected) {
+		expectedActual("Wrong number of field generators.", len(expected), len(generators), t)
+	}
+	for key, fieldgen := range expected {
+		actual := generators[key]
+		if actual.Name != fieldgen.Name || actual.Field != fieldgen.Field || actual.IsStateful != fieldgen.IsStateful {
+			expectedActual("Wrong field generator for "+key+".", fieldgen, actual, t)
+		}
+	}
+
+	for _, line := range []string{"@generator field: Message generateID", "@generator field: Message.ID", "@generator field: Message. generateID"} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "@enum" lines are parsed, with or without values, and
+// rejected without a probability.
+func TestEnum(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		"@enum: Mode 0.1",
+		`@enum: Method 0 "GET", "POST", strings.ToUpper("put")`,
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mode := wanteds[0].Enum["Mode"]
+	if mode.OutOfRange != 0.1 || len(mode.Values) != 0 {
+		expectedActual("Wrong enum.", Enum{OutOfRange: 0.1}, mode, t)
+	}
+
+	expected := []string{`"GET"`, `"POST"`, `strings.ToUpper("put")`}
+	method := wanteds[0].Enum["Method"]
+	if len(method.Values) != len(expected) {
+		expectedActual("Wrong enum values.", expected, method.Values, t)
+	}
+	for i, value := range expected {
+		if method.Values[i] != value {
+			expectedActual("Wrong enum value.", value, method.Values[i], t)
+		}
+	}
+
+	for _, line := range []string{"@enum: Mode", "@enum: Mode ModeA", "@enum: Mode 2", "@enum: Mode 0.5 ModeA ModeB", "@enum: Mode 0.5 A: 1"} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "@edge cases" lines give a probability, which may be
+// zero.
+func TestEdgeCases(t *testing.T) {
+	for line, expected := range map[string]float64{"@edge cases: 0.25": 0.25, "@edge cases: 0": 0} {
+		lines := []string{"@fuzz interface: Queue", "@known correct: newQueue", line}
+
+		wanteds, err := WantedFuzzersFromCommentLines(lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bias := wanteds[0].EdgeCases; bias == nil || *bias != expected {
+			expectedActual("Wrong edge case probability.", expected, bias, t)
+		}
+	}
+
+	for _, line := range []string{"@edge cases: 2", "@edge cases: -0.5", "@edge cases: often"} {
+		lines := []string{"@fuzz interface: Queue", "@known correct: newQueue", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "~" marks generators which are passed the size, for both
+// type and field generators.
+func TestSizedGenerator(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		"@generator: ~ generateName Name",
+		"@generator: ! ~ generateID ID",
+		"@generator field: Message.Body ~ generateBody",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Generator{
+		"Name": {IsSized: true, Name: "generateName"},
+		"ID":   {IsStateful: true, IsSized: true, Name: "generateID"},
+	}
+	for tystr, generator := range expected {
+		actual := wanteds[0].Generator[tystr]
+		if actual.Name != generator.Name || actual.IsStateful != generator.IsStateful || actual.IsSized != generator.IsSized {
+			expectedActual("Wrong generator for "+tystr+".", generator, actual, t)
+		}
+	}
+
+	if fieldgen := wanteds[0].FieldGenerator["Message.Body"]; !fieldgen.IsSized || fieldgen.IsStateful {
+		expectedActual("Wrong field generator.", FieldGenerator{IsSized: true, Name: "generateBody"}, fieldgen, t)
+	}
+}
+
+// Check that "state=" gives a generator its own initial state, and is
+// rejected for generators which are not stateful.
+func TestOwnGeneratorState(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		"@generator: ! generateID ID state=idState{next: 1}",
+		"@generator: ! ~ generateName []Name state=map[string]bool{}",
+		"@generator field: Message.Body ! generateBody state=0",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"ID": "idState{next: 1}", "[](Name)": "map[string]bool{}"}
+	for tystr, state := range expected {
+		if actual := wanteds[0].Generator[tystr].State; actual != state {
+			expectedActual("Wrong state for "+tystr+".", state, actual, t)
+		}
+	}
+	if state := wanteds[0].FieldGenerator["Message.Body"].State; state != "0" {
+		expectedActual("Wrong field generator state.", "0", state, t)
+	}
+
+	for _, line := range []string{"@generator: generateID ID state=0", "@generator: ! generateID ID state=", "@generator: ! generateID ID other=0"} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "@generator: expr" lines take the type from a conversion
+// or composite literal, and reject invalid expressions.
+func TestGeneratorExpression(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		"@generator: expr ID(%rand.Intn(64))",
+		`@generator: expr model.Method([]string{"GET", "POST"}[%rand.Intn(2)])`,
+		"@generator: expr Pair{A: %rand.Intn(%size)}",
+		"@generator field: Message.Body expr fmt.Sprint(%rand.Int())",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"ID":           "ID(%rand.Intn(64))",
+		"model.Method": `model.Method([]string{"GET", "POST"}[%rand.Intn(2)])`,
+		"Pair":         "Pair{A: %rand.Intn(%size)}",
+	}
+	generators := wanteds[0].Generator
+	if len(generators) != len(expected) {
+		expectedActual("Wrong number of generators.", len(expected), len(generators), t)
+	}
+	for tystr, expr := range expected {
+		if generators[tystr].Expression != expr {
+			expectedActual("Wrong expression for "+tystr+".", expr, generators[tystr].Expression, t)
+		}
+	}
+	if expr := wanteds[0].FieldGenerator["Message.Body"].Expression; expr != "fmt.Sprint(%rand.Int())" {
+		expectedActual("Wrong field generator expression.", "fmt.Sprint(%rand.Int())", expr, t)
+	}
+
+	for _, line := range []string{"@generator: expr ID(%rand.Intn(64)", "@generator: expr %rand.Intn(64)", "@generator: expr ID(%rand.Int()) + 1 +"} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+
+	// A function call looks like a conversion until the types are
+	// known.
+	src := `
+package example
+
+import "strconv"
+
+type ID int
+
+func newID(n int) ID { return ID(n) }
+
+var _ = strconv.Itoa
+
+type Store interface {
+	Get(id ID) string
+}
+`
+	env := typeCheckImporting(src, t)
+	interfaces := parseInterfaces(src, t)
+	for _, line := range []string{"@generator: expr newID(%rand.Intn(64))", "@generator: expr strconv.Itoa(%rand.Int())", "@generator: expr Missing(%rand.Int())"} {
+		wanteds, err := WantedFuzzersFromCommentLines([]string{"@fuzz interface: Store", line})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, errs := reconcileFuzzers(env, interfaces, wanteds); len(errs) == 0 {
+			t.Fatalf("Expected an error reconciling '%s'.", line)
+		}
+	}
+
+	wanteds, err = WantedFuzzersFromCommentLines([]string{"@fuzz interface: Store", "@generator: expr ID(%rand.Intn(64))"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := reconcileFuzzers(env, interfaces, wanteds); len(errs) > 0 {
+		t.Fatal(errorList("Unexpected errors reconciling a conversion", errs))
+	}
+
+	// Without type information even a conversion can't be checked.
+	if _, errs := reconcileFuzzers(nil, interfaces, wanteds); len(errs) == 0 {
+		t.Fatal("Expected an error reconciling an expression generator without type information.")
+	}
+}
+
+// Check that generators are parsed for method parameters, and not
+// mistaken for generators of types.
+func TestParamGenerator(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		"@generator Get.offset: ~GenOffset",
+		"@generator Get.limit: expr 1 + %rand.Intn(%size)",
+		"@generator Put.keys: !GenKeys state=0",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Generator{
+		"Get.offset": Generator{IsSized: true, Name: "GenOffset"},
+		"Get.limit":  Generator{Expression: "1 + %rand.Intn(%size)"},
+		"Put.keys":   Generator{IsStateful: true, Name: "GenKeys", State: "0"},
+	}
+	generators := wanteds[0].ParamGenerator
+	if len(generators) != len(expected) {
+		expectedActual("Wrong number of parameter generators.", len(expected), len(generators), t)
+	}
+	for key, generator := range expected {
+		if generators[key] != generator {
+			expectedActual("Wrong generator for "+key+".", generator, generators[key], t)
+		}
+	}
+	if len(wanteds[0].Generator) != 0 {
+		expectedActual("Parameter generators mistaken for type generators.", 0, len(wanteds[0].Generator), t)
+	}
+}
+
+// Check that "@generator regex" lines give a regular expression for a
+// type, and are rejected if it is missing or invalid.
+func TestRegexGenerator(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		`@generator regex: model.ID "^[a-z]{2,4}-[0-9]+$"`,
+		"@generator regex: Path `(/[a-z]+)*`",
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"model.ID": "^[a-z]{2,4}-[0-9]+$",
+		"Path":     "(/[a-z]+)*",
+	}
+	generators := wanteds[0].Generator
+	if len(generators) != len(expected) {
+		expectedActual("Wrong number of generators.", len(expected), len(generators), t)
+	}
+	for tystr, pattern := range expected {
+		if generators[tystr].Regexp != pattern {
+			expectedActual("Wrong regular expression for "+tystr+".", pattern, generators[tystr].Regexp, t)
+		}
+	}
+
+	for _, line := range []string{`@generator regex: ID "[a-z"`, "@generator regex: ID", `@generator regex: "[a-z]"`} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}
+
+// Check that "@generator grammar" lines build up a grammar for a type,
+// one rule at a time, and are rejected if a rule is malformed.
+func TestGrammarGenerator(t *testing.T) {
+	lines := []string{
+		"@fuzz interface: Store",
+		`@generator grammar: Query query = pair | pair "&" query`,
+		"@generator grammar: Query pair = key \"=\" `[a-z]*`",
+		`@generator grammar: Query key = "page"`,
+		`@generator grammar: Query key = "limit" | ""`,
+	}
+
+	wanteds, err := WantedFuzzersFromCommentLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []GrammarRule{
+		{Name: "query", Alternatives: [][]GrammarSymbol{{{Rule: "pair"}}, {{Rule: "pair"}, {Text: "&"}, {Rule: "query"}}}},
+		{Name: "pair", Alternatives: [][]GrammarSymbol{{{Rule: "key"}, {Text: "="}, {Regexp: "[a-z]*"}}}},
+		{Name: "key", Alternatives: [][]GrammarSymbol{{{Text: "page"}}, {{Text: "limit"}}, {{}}}},
+	}
+	grammar := wanteds[0].Generator["Query"].Grammar
+	if grammar == nil {
+		t.Fatal("Expected a grammar for Query.")
+	}
+	if !reflect.DeepEqual(grammar.Rules, expected) {
+		expectedActual("Wrong grammar.", expected, grammar.Rules, t)
+	}
+
+	for _, line := range []string{
+		`@generator grammar: Query`,
+		`@generator grammar: Query query`,
+		`@generator grammar: Query query = pair |`,
+		`@generator grammar: Query query = pair + pair`,
+		"@generator grammar: Query query = `[a-z`",
+	} {
+		lines := []string{"@fuzz interface: Store", line}
+		if _, err := WantedFuzzersFromCommentLines(lines); err == nil {
+			t.Fatalf("Expected an error parsing '%s'.", line)
+		}
+	}
+}